@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/bench"
+)
+
+// runBench implements the "featherjet bench" subcommand and returns the
+// process exit code
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	url := flags.String("url", "http://localhost:8080/", "URL to request")
+	concurrency := flags.Int("concurrency", 10, "Number of concurrent workers")
+	duration := flags.Duration("duration", 10*time.Second, "How long to run")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// Ctrl-C ends the run early but still prints the report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Benchmarking %s with %d workers for %v\n", *url, *concurrency, *duration)
+	result, err := bench.Run(ctx, bench.Options{
+		URL:         *url,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	result.Report(os.Stdout)
+	return 0
+}
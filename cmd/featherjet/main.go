@@ -2,18 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/featherjet/featherjet/internal/config"
 	"github.com/featherjet/featherjet/internal/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
 	flag.Parse()
@@ -25,7 +30,10 @@ func main() {
 	}
 
 	// Create and configure the server
-	srv := server.New(cfg)
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -33,24 +41,55 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		if err := srv.Start(); err != nil {
+		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Server failed to start: %v", err)
 		}
 	}()
 
-	log.Printf("FeatherJet server starting on %s:%d", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("Serving static files from: %s", cfg.Static.Directory)
-	log.Printf("Log level: %s", cfg.Logging.Level)
-
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	notifyRestart(sigChan)
+	notifyReload(sigChan)
+	if cfg.Server.EnableStackDumpSignal {
+		notifyStackDump(sigChan)
+	}
 
-	<-sigChan
+	for {
+		sig := <-sigChan
+		if isStackDumpSignal(sig) {
+			srv.LogStacks()
+			continue
+		}
+		if isReloadSignal(sig) {
+			// Only settings that can change in place are applied
+			newCfg, err := config.Load(*configPath)
+			if err == nil {
+				err = srv.ReloadCacheHeaders(newCfg)
+			}
+			if err != nil {
+				log.Printf("Configuration reload failed: %v", err)
+			}
+			continue
+		}
+		if !isRestartSignal(sig) {
+			break
+		}
+
+		// Hand the listening socket to a new process, then drain this one
+		child, err := srv.Restart()
+		if err != nil {
+			log.Printf("Graceful restart failed: %v", err)
+			continue
+		}
+		log.Printf("Started new FeatherJet process (pid %d), draining connections", child.Pid)
+		break
+	}
 	log.Println("Shutting down FeatherJet server...")
+	srv.BeginDrain()
 
 	// Graceful shutdown with timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 30*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, cfg.Server.ShutdownTimeout)
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyRestart relays SIGUSR2, which triggers a zero-downtime restart
+func notifyRestart(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// isRestartSignal reports whether sig requests a graceful restart
+func isRestartSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+// notifyReload relays SIGHUP, which reloads the static cache headers
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// isReloadSignal reports whether sig requests a configuration reload
+func isReloadSignal(sig os.Signal) bool {
+	return sig == syscall.SIGHUP
+}
+
+// notifyStackDump relays SIGQUIT, which logs all goroutine stacks instead of
+// crashing the process
+func notifyStackDump(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGQUIT)
+}
+
+// isStackDumpSignal reports whether sig requests a goroutine stack dump
+func isStackDumpSignal(sig os.Signal) bool {
+	return sig == syscall.SIGQUIT
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyRestart is a no-op; socket handoff is not supported on Windows
+func notifyRestart(c chan<- os.Signal) {}
+
+// isRestartSignal always reports false on Windows
+func isRestartSignal(sig os.Signal) bool {
+	return false
+}
+
+// notifyReload is a no-op; there is no SIGHUP on Windows
+func notifyReload(c chan<- os.Signal) {}
+
+// isReloadSignal always reports false on Windows
+func isReloadSignal(sig os.Signal) bool {
+	return false
+}
+
+// notifyStackDump is a no-op; there is no SIGQUIT on Windows
+func notifyStackDump(c chan<- os.Signal) {}
+
+// isStackDumpSignal always reports false on Windows
+func isStackDumpSignal(sig os.Signal) bool {
+	return false
+}
@@ -0,0 +1,173 @@
+// Package bench fires concurrent HTTP requests at a target and aggregates
+// throughput and latency figures for capacity planning and warmup
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options configures a benchmark run
+type Options struct {
+	URL         string
+	Concurrency int
+	Duration    time.Duration
+
+	// Client sends the requests; when nil, a client keeping an idle
+	// connection for every worker is used
+	Client *http.Client
+}
+
+// Result aggregates the outcome of a run
+type Result struct {
+	Requests  int
+	Errors    int
+	Elapsed   time.Duration
+	Statuses  map[int]int
+	Latencies []time.Duration
+}
+
+// Run sends GET requests to opts.URL from opts.Concurrency workers until
+// opts.Duration has passed or ctx is cancelled
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if opts.URL == "" {
+		return nil, errors.New("bench: url is required")
+	}
+	if opts.Concurrency < 1 {
+		return nil, fmt.Errorf("bench: invalid concurrency %d", opts.Concurrency)
+	}
+	if opts.Duration <= 0 {
+		return nil, fmt.Errorf("bench: invalid duration %v", opts.Duration)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = newClient(opts.Concurrency)
+		defer client.CloseIdleConnections()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	result := &Result{Statuses: make(map[int]int)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				status, latency, err := fetch(ctx, client, opts.URL)
+				// Requests cut short by the end of the run are not counted
+				if ctx.Err() != nil {
+					return
+				}
+
+				mu.Lock()
+				result.Requests++
+				if err != nil {
+					result.Errors++
+				} else {
+					result.Statuses[status]++
+					result.Latencies = append(result.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	return result, nil
+}
+
+// newClient returns a client whose transport keeps concurrency idle
+// connections to the target. http.DefaultTransport keeps only two, so the
+// other workers would measure connection setup instead of the server.
+func newClient(concurrency int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	if transport.MaxIdleConns < concurrency {
+		transport.MaxIdleConns = concurrency
+	}
+	return &http.Client{Transport: transport}
+}
+
+// fetch performs one request, reading the whole body so the latency covers
+// the full response
+func fetch(ctx context.Context, client *http.Client, url string) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, 0, err
+	}
+	return resp.StatusCode, time.Since(start), nil
+}
+
+// Throughput returns completed requests per second
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests-r.Errors) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the p-th percentile (0-100) of samples using the
+// nearest-rank method. It returns 0 for an empty sample set.
+func Percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// Report writes a human-readable summary of r to w
+func (r *Result) Report(w io.Writer) {
+	fmt.Fprintf(w, "Requests:   %d (%d errors) in %v\n", r.Requests, r.Errors, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput: %.1f req/s\n", r.Throughput())
+
+	statuses := make([]int, 0, len(r.Statuses))
+	for status := range r.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "Status %d: %d\n", status, r.Statuses[status])
+	}
+
+	fmt.Fprintln(w, "Latency:")
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Fprintf(w, "  p%-4v %v\n", p, Percentile(r.Latencies, p))
+	}
+}
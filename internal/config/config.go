@@ -1,8 +1,21 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -16,24 +29,284 @@ type Config struct {
 		ReadTimeout  time.Duration `yaml:"read_timeout"`
 		WriteTimeout time.Duration `yaml:"write_timeout"`
 		IdleTimeout  time.Duration `yaml:"idle_timeout"`
+
+		BodyReadTimeout time.Duration `yaml:"body_read_timeout"`
+		ReusePort       bool          `yaml:"reuse_port"`
+		ListenBacklog   int           `yaml:"listen_backlog"`
+		AdminAddr       string        `yaml:"admin_addr"`
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		MaxURILength    int           `yaml:"max_uri_length"`
+		TCPKeepAlive    time.Duration `yaml:"tcp_keep_alive"`
+		ProxyProtocol   bool          `yaml:"proxy_protocol"`
+		TrustedProxies  []string      `yaml:"trusted_proxies"`
+		EnableExpvar    bool          `yaml:"enable_expvar"`
+		ServerHeader    string        `yaml:"server_header"`
+		SSEIdleTimeout  time.Duration `yaml:"sse_idle_timeout"`
+
+		StreamShutdownGrace time.Duration `yaml:"stream_shutdown_grace"`
+		HeaderReadDeadline  time.Duration `yaml:"header_read_deadline"`
+		MaxRequestBodyBytes int64         `yaml:"max_request_body_bytes"`
+		MaxConnectionsPerIP int           `yaml:"max_connections_per_ip"`
+
+		RequestTimeout   time.Duration  `yaml:"request_timeout"`
+		RouteTimeouts    []RouteTimeout `yaml:"route_timeouts"`
+		EmitServerTiming bool           `yaml:"emit_server_timing"`
+
+		EnableStackDumpSignal bool `yaml:"enable_stack_dump_signal"`
+
+		EnableHTTP3 bool `yaml:"enable_http3"`
+
+		Maintenance           bool     `yaml:"maintenance"`
+		MaintenanceAllowCIDRs []string `yaml:"maintenance_allow_cidrs"`
+
+		ReadinessCheckDisk    bool `yaml:"readiness_check_disk"`
+		ReadinessCheckBackend bool `yaml:"readiness_check_backend"`
 	} `yaml:"server"`
 
 	Static struct {
-		Directory   string `yaml:"directory"`
-		CacheMaxAge string `yaml:"cache_max_age"`
+		Directory        string   `yaml:"directory"`
+		CacheMaxAge      string   `yaml:"cache_max_age"`
+		ImmutablePattern string   `yaml:"immutable_pattern"`
+		NotFoundPage     string   `yaml:"not_found_page"`
+		BlockDotfiles    bool     `yaml:"block_dotfiles"`
+		DotfileAllowlist []string `yaml:"dotfile_allowlist"`
+		RobotsTxt        string   `yaml:"robots_txt"`
+		SecurityTxt      string   `yaml:"security_txt"`
+		DefaultCharset   string   `yaml:"default_charset"`
+		ListingSort      string   `yaml:"listing_sort"`
+		RequireDirectory bool     `yaml:"require_directory"`
+		ShowWelcomePage  bool     `yaml:"show_welcome_page"`
+		GenerateSitemap  bool     `yaml:"generate_sitemap"`
+		EmitSRI          bool     `yaml:"emit_sri"`
+
+		LanguageNegotiation bool   `yaml:"language_negotiation"`
+		DefaultLanguage     string `yaml:"default_language"`
+
+		PrecompressOnStart bool `yaml:"precompress_on_start"`
+		PrecompressWorkers int  `yaml:"precompress_workers"`
+
+		WatchInterval time.Duration `yaml:"watch_interval"`
+
+		Redirects []Redirect `yaml:"redirects"`
+		Rewrites  []Rewrite  `yaml:"rewrites"`
+
+		ResponseHeaders map[string]string `yaml:"response_headers"`
 	} `yaml:"static"`
 
+	TLS struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+
+		AutoCert struct {
+			Domains  []string `yaml:"domains"`
+			CacheDir string   `yaml:"cache_dir"`
+			Email    string   `yaml:"email"`
+			HTTPAddr string   `yaml:"http_addr"`
+		} `yaml:"autocert"`
+
+		CipherSuites             []string `yaml:"cipher_suites"`
+		PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+	} `yaml:"tls"`
+
+	Security struct {
+		HSTSMaxAge            int  `yaml:"hsts_max_age"`
+		HSTSIncludeSubDomains bool `yaml:"hsts_include_subdomains"`
+		HSTSPreload           bool `yaml:"hsts_preload"`
+
+		RequiredHeaders map[string]string `yaml:"required_headers"`
+	} `yaml:"security"`
+
+	Proxy struct {
+		// Enabled is nil unless set explicitly; see ProxyEnabled
+		Enabled *bool `yaml:"enabled"`
+
+		Target               string            `yaml:"target"`
+		Targets              []ProxyTarget     `yaml:"targets"`
+		StripResponseHeaders []string          `yaml:"strip_response_headers"`
+		SetResponseHeaders   map[string]string `yaml:"set_response_headers"`
+		MaxRequestTimeout    time.Duration     `yaml:"max_request_timeout"`
+		FlushInterval        time.Duration     `yaml:"flush_interval"`
+		MaxConnAge           time.Duration     `yaml:"max_conn_age"`
+		ForwardTrailers      bool              `yaml:"forward_trailers"`
+		FallbackToStatic     bool              `yaml:"fallback_to_static"`
+		RequireHTTPS         bool              `yaml:"require_https"`
+		ErrorPage            string            `yaml:"error_page"`
+		MaxResponseHeaders   int               `yaml:"max_response_headers"`
+		GRPCWeb              bool              `yaml:"grpc_web"`
+		ResponseHeaders      map[string]string `yaml:"response_headers"`
+		BufferResponses      bool              `yaml:"buffer_responses"`
+		BufferMaxBytes       int               `yaml:"buffer_max_bytes"`
+		Expect100Continue    bool              `yaml:"expect_100_continue"`
+
+		MaxConcurrentPerBackend int `yaml:"max_concurrent_per_backend"`
+		MaxRequestHeaderBytes   int `yaml:"max_request_header_bytes"`
+
+		HealthCheckTimeout time.Duration `yaml:"health_check_timeout"`
+
+		WebSocketPingInterval time.Duration `yaml:"websocket_ping_interval"`
+
+		AllowCanaryHeader bool     `yaml:"allow_canary_header"`
+		CanaryAllowCIDRs  []string `yaml:"canary_allow_cidrs"`
+		CanaryBackends    []string `yaml:"canary_backends"`
+
+		TLS struct {
+			CACertFile         string `yaml:"ca_cert_file"`
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+			CertFile           string `yaml:"cert_file"`
+			KeyFile            string `yaml:"key_file"`
+		} `yaml:"tls"`
+	} `yaml:"proxy"`
+
 	Logging struct {
 		Level                string `yaml:"level"`
 		EnableRequestLogging bool   `yaml:"enable_request_logging"`
+		AccessLogFormat      string `yaml:"access_log_format"`
+		Format               string `yaml:"format"`
+
+		AccessLogFile      string `yaml:"access_log_file"`
+		AccessLogMaxSizeMB int    `yaml:"access_log_max_size_mb"`
+		CompressRotated    bool   `yaml:"compress_rotated"`
+
+		TimeFormat string `yaml:"time_format"`
+		TimeZone   string `yaml:"time_zone"`
+
+		LogQueryString    bool     `yaml:"log_query_string"`
+		RedactQueryParams []string `yaml:"redact_query_params"`
+
+		StatusFilter []string `yaml:"status_filter"`
+
+		LogUpstreamTiming bool `yaml:"log_upstream_timing"`
 	} `yaml:"logging"`
 
 	Middleware struct {
-		EnableCORS        bool `yaml:"enable_cors"`
-		EnableCompression bool `yaml:"enable_compression"`
+		EnableCORS          bool     `yaml:"enable_cors"`
+		EnableCompression   bool     `yaml:"enable_compression"`
+		CompressionMinBytes int      `yaml:"compression_min_bytes"`
+		BlockedUserAgents   []string `yaml:"blocked_user_agents"`
+		PathAllowPatterns   []string `yaml:"path_allow_patterns"`
+		PathDenyPatterns    []string `yaml:"path_deny_patterns"`
+
+		AdaptiveCompression            bool `yaml:"adaptive_compression"`
+		AdaptiveCompressionMaxInFlight int  `yaml:"adaptive_compression_max_in_flight"`
+
+		CORSPolicy CORSPolicy  `yaml:"cors_policy"`
+		CORSRoutes []CORSRoute `yaml:"cors_routes"`
+
+		ContextHeaders []ContextHeader `yaml:"context_headers"`
 	} `yaml:"middleware"`
+
+	Cache struct {
+		SingleFlight  bool        `yaml:"single_flight"`
+		MaxFileSizeMB int         `yaml:"max_file_size_mb"`
+		Rules         []CacheRule `yaml:"rules"`
+	} `yaml:"cache"`
+
+	API struct {
+		DisabledEndpoints []string `yaml:"disabled_endpoints"`
+		PrettyJSON        bool     `yaml:"pretty_json"`
+
+		Custom map[string]CustomEndpoint `yaml:"custom"`
+	} `yaml:"api"`
+}
+
+// CustomEndpoint is a fixed JSON response served at an /api path, for mocks
+// and feature flags. A zero Status means 200.
+type CustomEndpoint struct {
+	Status int    `yaml:"status"`
+	Body   string `yaml:"body"`
+}
+
+// Redirect maps a legacy URL to its new location. A From ending in "/*"
+// matches the whole prefix, and a "*" in To is replaced by the rest of the path.
+type Redirect struct {
+	From   string `yaml:"from"`
+	To     string `yaml:"to"`
+	Status int    `yaml:"status"`
+}
+
+// Rewrite serves To in place of From without changing the client URL, using
+// the same "/prefix/*" matching as Redirect
+type Rewrite struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// validateExclusive rejects options that cannot be combined, naming both
+// fields so the operator knows which one to drop
+func (c *Config) validateExclusive() error {
+	conflicts := []struct {
+		first, second string
+		conflict      bool
+	}{
+		{"tls.cert_file", "tls.autocert.domains", c.TLS.CertFile != "" && c.AutoCertEnabled()},
+		{"proxy.tls.insecure_skip_verify", "proxy.tls.ca_cert_file", c.Proxy.TLS.InsecureSkipVerify && c.Proxy.TLS.CACertFile != ""},
+		{"logging.time_format: unix", "logging.time_zone", c.Logging.TimeFormat == "unix" && c.Logging.TimeZone != ""},
+		// Connections are counted at accept time, before the PROXY header
+		// names the real client, so every client would share the balancer's IP
+		{"server.max_connections_per_ip", "server.proxy_protocol", c.Server.MaxConnectionsPerIP > 0 && c.Server.ProxyProtocol},
+	}
+
+	for _, pair := range conflicts {
+		if pair.conflict {
+			return fmt.Errorf("%s and %s are mutually exclusive", pair.first, pair.second)
+		}
+	}
+	return nil
+}
+
+// CORSPolicy sets the CORS headers for a group of routes. Empty lists keep
+// the defaults: any origin, the common methods, and Content-Type and
+// Authorization headers.
+type CORSPolicy struct {
+	AllowOrigins []string `yaml:"allow_origins"`
+	AllowMethods []string `yaml:"allow_methods"`
+	AllowHeaders []string `yaml:"allow_headers"`
+}
+
+// CORSRoute overrides the default CORS policy for paths under PathPrefix; the
+// longest matching prefix wins
+type CORSRoute struct {
+	PathPrefix string `yaml:"path_prefix"`
+	CORSPolicy `yaml:",inline"`
+}
+
+// ContextHeader copies a request header such as X-Tenant-ID into the request
+// context and on to the backend. Requests whose value does not fully match
+// Pattern are rejected.
+type ContextHeader struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// RouteTimeout overrides Server.RequestTimeout for paths under PathPrefix;
+// the longest matching prefix wins and a zero Timeout means no limit
+type RouteTimeout struct {
+	PathPrefix string        `yaml:"path_prefix"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// ProxyTarget is one backend of a load-balanced proxy. Requests are spread
+// across targets in proportion to Weight (0 means 1).
+type ProxyTarget struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// CacheRule caches GET responses whose media type matches ContentType (a
+// path.Match pattern such as "image/*") for TTL, as long as the body is no
+// larger than MaxSize bytes (0 means no limit)
+type CacheRule struct {
+	ContentType string        `yaml:"content_type"`
+	TTL         time.Duration `yaml:"ttl"`
+	MaxSize     int64         `yaml:"max_size"`
 }
 
+// DefaultImmutablePattern matches fingerprinted asset names such as app.4f3a2b.js
+const DefaultImmutablePattern = `\.[0-9a-fA-F]{6,}\.[A-Za-z0-9]+$`
+
+// HSTSPreloadMinAge is the minimum HSTS max-age (one year) accepted by the preload list
+const HSTSPreloadMinAge = 31536000
+
 // Load reads and parses the configuration file
 func Load(configPath string) (*Config, error) {
 	// Set default values
@@ -43,12 +316,35 @@ func Load(configPath string) (*Config, error) {
 	cfg.Server.ReadTimeout = 30 * time.Second
 	cfg.Server.WriteTimeout = 30 * time.Second
 	cfg.Server.IdleTimeout = 120 * time.Second
+	cfg.Server.StreamShutdownGrace = 5 * time.Second
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Server.MaxURILength = 8192
+	cfg.Server.TCPKeepAlive = 3 * time.Minute
 	cfg.Static.Directory = "./public"
 	cfg.Static.CacheMaxAge = "3600"
+	cfg.Static.ImmutablePattern = DefaultImmutablePattern
+	cfg.Static.BlockDotfiles = true
+	cfg.Static.DotfileAllowlist = []string{".well-known"}
+	cfg.Static.DefaultCharset = "utf-8"
+	cfg.Static.PrecompressWorkers = 4
+	cfg.TLS.AutoCert.HTTPAddr = ":80"
+	cfg.Proxy.Target = "http://localhost:8080"
+	cfg.Proxy.MaxRequestTimeout = 30 * time.Second
+	cfg.Proxy.ForwardTrailers = true
+	cfg.Proxy.MaxResponseHeaders = 100
+	cfg.Proxy.BufferMaxBytes = 1 << 20
+	cfg.Proxy.HealthCheckTimeout = 2 * time.Second
+	cfg.Proxy.Expect100Continue = true
 	cfg.Logging.Level = "info"
 	cfg.Logging.EnableRequestLogging = true
+	cfg.Logging.Format = "text"
+	cfg.Logging.AccessLogMaxSizeMB = 100
+	cfg.Logging.LogQueryString = true
 	cfg.Middleware.EnableCORS = true
 	cfg.Middleware.EnableCompression = false
+	cfg.Middleware.CompressionMinBytes = 1024
+	cfg.Middleware.AdaptiveCompressionMaxInFlight = 64
+	cfg.Cache.MaxFileSizeMB = 32
 
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -56,30 +352,376 @@ func Load(configPath string) (*Config, error) {
 		return cfg, nil
 	}
 
+	if err := loadFile(cfg, configPath, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// includeDirective lists further config files to merge after a document
+type includeDirective struct {
+	Include []string `yaml:"include"`
+}
+
+// loadFile merges every YAML document in configPath into cfg, followed by the
+// files it includes. Later documents and includes override earlier values.
+// active holds the files currently being loaded to detect include cycles.
+func loadFile(cfg *Config, configPath string, active map[string]bool) error {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config file %s: %w", configPath, err)
+	}
+
+	if active[absPath] {
+		return &ErrConfigParse{Path: configPath, Err: errors.New("cyclic config include")}
+	}
+	active[absPath] = true
+	defer delete(active, absPath)
+
 	// Read config file
-	data, err := os.ReadFile(configPath)
+	data, err := os.ReadFile(absPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			return &ErrConfigNotFound{Path: configPath, Err: err}
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Parse YAML
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	// Parse YAML, one document at a time
+	var includes []string
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return &ErrConfigParse{Path: configPath, Err: err}
+		}
+
+		if err := resolveSecrets(&doc, filepath.Dir(absPath)); err != nil {
+			return &ErrConfigParse{Path: configPath, Err: err}
+		}
+
+		if err := doc.Decode(cfg); err != nil {
+			return &ErrConfigParse{Path: configPath, Err: err}
+		}
+
+		var directive includeDirective
+		if err := doc.Decode(&directive); err != nil {
+			return &ErrConfigParse{Path: configPath, Err: fmt.Errorf("invalid include directive: %w", err)}
+		}
+		includes = append(includes, directive.Include...)
 	}
 
-	return cfg, nil
+	// Relative includes are resolved against the including file's directory
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(absPath), include)
+		}
+
+		if err := loadFile(cfg, include, active); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// Validate checks if the configuration is valid
+// Validate checks if the configuration is valid. Failures are reported as
+// *ErrConfigInvalid.
 func (c *Config) Validate() error {
+	if err := c.validate(); err != nil {
+		return &ErrConfigInvalid{Err: err}
+	}
+	return nil
+}
+
+// ValidateWithWarnings is Validate that also returns the Warnings for a
+// valid configuration, so callers can report both in one step
+func (c *Config) ValidateWithWarnings() ([]string, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c.Warnings(), nil
+}
+
+// validate returns the first problem found in the configuration
+func (c *Config) validate() error {
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid port number: %d", c.Server.Port)
 	}
 
+	if err := c.validateExclusive(); err != nil {
+		return err
+	}
+
+	if !validHost(c.Server.Host) {
+		return fmt.Errorf("invalid server host: %q", c.Server.Host)
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_timeout", c.Server.ReadTimeout},
+		{"write_timeout", c.Server.WriteTimeout},
+		{"idle_timeout", c.Server.IdleTimeout},
+		{"body_read_timeout", c.Server.BodyReadTimeout},
+		{"sse_idle_timeout", c.Server.SSEIdleTimeout},
+		{"stream_shutdown_grace", c.Server.StreamShutdownGrace},
+		{"header_read_deadline", c.Server.HeaderReadDeadline},
+		{"request_timeout", c.Server.RequestTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			return fmt.Errorf("invalid server %s: %v", timeout.name, timeout.value)
+		}
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("server shutdown_timeout must be positive, got %v", c.Server.ShutdownTimeout)
+	}
+
+	if c.Server.MaxURILength < 0 {
+		return fmt.Errorf("invalid max uri length: %d", c.Server.MaxURILength)
+	}
+
+	if c.Server.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("invalid max request body bytes: %d", c.Server.MaxRequestBodyBytes)
+	}
+
+	if c.Server.ListenBacklog < 0 {
+		return fmt.Errorf("invalid listen backlog: %d", c.Server.ListenBacklog)
+	}
+
+	for _, route := range c.Server.RouteTimeouts {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("invalid route timeout path prefix: %q", route.PathPrefix)
+		}
+		if route.Timeout < 0 {
+			return fmt.Errorf("invalid timeout for route %s: %v", route.PathPrefix, route.Timeout)
+		}
+	}
+
+	if c.Server.MaxConnectionsPerIP < 0 {
+		return fmt.Errorf("invalid server max connections per ip: %d", c.Server.MaxConnectionsPerIP)
+	}
+
 	if c.Static.Directory == "" {
 		return fmt.Errorf("static directory cannot be empty")
 	}
 
+	if c.Static.ImmutablePattern != "" {
+		if _, err := regexp.Compile(c.Static.ImmutablePattern); err != nil {
+			return fmt.Errorf("invalid static immutable pattern: %w", err)
+		}
+	}
+
+	if c.Static.WatchInterval < 0 {
+		return fmt.Errorf("invalid static watch interval: %v", c.Static.WatchInterval)
+	}
+
+	if c.Static.PrecompressWorkers < 0 {
+		return fmt.Errorf("invalid static precompress workers: %d", c.Static.PrecompressWorkers)
+	}
+
+	switch c.Static.ListingSort {
+	case "", "name", "name_desc", "size", "size_desc", "modtime", "modtime_desc":
+	default:
+		return fmt.Errorf("invalid static listing sort: %s", c.Static.ListingSort)
+	}
+
+	for _, redirect := range c.Static.Redirects {
+		if !strings.HasPrefix(redirect.From, "/") || redirect.To == "" {
+			return fmt.Errorf("invalid static redirect from %q to %q", redirect.From, redirect.To)
+		}
+		switch redirect.Status {
+		case 0, 301, 302, 307, 308:
+		default:
+			return fmt.Errorf("invalid static redirect status for %q: %d", redirect.From, redirect.Status)
+		}
+	}
+
+	for _, rewrite := range c.Static.Rewrites {
+		if !strings.HasPrefix(rewrite.From, "/") || !strings.HasPrefix(rewrite.To, "/") {
+			return fmt.Errorf("invalid static rewrite from %q to %q", rewrite.From, rewrite.To)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+
+	if c.AutoCertEnabled() && c.TLS.AutoCert.CacheDir == "" {
+		return fmt.Errorf("tls autocert requires a cache_dir")
+	}
+
+	if _, err := c.CipherSuiteIDs(); err != nil {
+		return err
+	}
+
+	if c.Server.EnableHTTP3 && !c.TLSEnabled() {
+		return fmt.Errorf("server enable_http3 requires tls")
+	}
+
+	if c.Security.HSTSMaxAge < 0 {
+		return fmt.Errorf("invalid hsts max-age: %d", c.Security.HSTSMaxAge)
+	}
+
+	if c.Security.HSTSPreload {
+		if c.Security.HSTSMaxAge < HSTSPreloadMinAge {
+			return fmt.Errorf("hsts preload requires a max-age of at least %d seconds, got %d", HSTSPreloadMinAge, c.Security.HSTSMaxAge)
+		}
+		if !c.Security.HSTSIncludeSubDomains {
+			return fmt.Errorf("hsts preload requires include_subdomains to be enabled")
+		}
+	}
+
+	for name, value := range c.Security.RequiredHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid required header name: %q", name)
+		}
+		if strings.TrimSpace(value) == "" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for required header %s: %q", name, value)
+		}
+	}
+
+	for _, header := range c.Middleware.ContextHeaders {
+		if !validHeaderName(header.Name) {
+			return fmt.Errorf("invalid context header name: %q", header.Name)
+		}
+		if header.Pattern == "" {
+			return fmt.Errorf("context header %s requires a pattern", header.Name)
+		}
+		if _, err := regexp.Compile(header.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern for context header %s: %w", header.Name, err)
+		}
+	}
+
+	if c.Proxy.Enabled != nil && *c.Proxy.Enabled && c.Proxy.Target == "" && len(c.Proxy.Targets) == 0 {
+		return fmt.Errorf("proxy is enabled but has no target")
+	}
+
+	if c.Proxy.Target != "" {
+		target, err := url.Parse(c.Proxy.Target)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("invalid proxy target: %q", c.Proxy.Target)
+		}
+	}
+
+	for _, backend := range c.Proxy.Targets {
+		target, err := url.Parse(backend.URL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("invalid proxy target: %q", backend.URL)
+		}
+		if backend.Weight < 0 {
+			return fmt.Errorf("invalid proxy target weight for %s: %d", backend.URL, backend.Weight)
+		}
+	}
+
+	for _, backend := range c.Proxy.CanaryBackends {
+		target, err := url.Parse(backend)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("invalid proxy canary backend: %q", backend)
+		}
+	}
+
+	if c.Proxy.MaxRequestTimeout < 0 {
+		return fmt.Errorf("invalid proxy max request timeout: %v", c.Proxy.MaxRequestTimeout)
+	}
+
+	if c.Proxy.MaxConcurrentPerBackend < 0 {
+		return fmt.Errorf("invalid proxy max concurrent per backend: %d", c.Proxy.MaxConcurrentPerBackend)
+	}
+
+	if c.Server.ReadinessCheckBackend && c.Proxy.HealthCheckTimeout <= 0 {
+		return fmt.Errorf("proxy health_check_timeout must be positive, got %v", c.Proxy.HealthCheckTimeout)
+	}
+
+	if c.Proxy.WebSocketPingInterval < 0 {
+		return fmt.Errorf("invalid proxy websocket ping interval: %v", c.Proxy.WebSocketPingInterval)
+	}
+
+	if c.Proxy.MaxRequestHeaderBytes < 0 {
+		return fmt.Errorf("invalid proxy max request header bytes: %d", c.Proxy.MaxRequestHeaderBytes)
+	}
+
+	if c.Proxy.MaxResponseHeaders < 0 {
+		return fmt.Errorf("invalid proxy max response headers: %d", c.Proxy.MaxResponseHeaders)
+	}
+
+	if c.Proxy.MaxConnAge < 0 {
+		return fmt.Errorf("invalid proxy max conn age: %v", c.Proxy.MaxConnAge)
+	}
+
+	if (c.Proxy.TLS.CertFile == "") != (c.Proxy.TLS.KeyFile == "") {
+		return fmt.Errorf("proxy tls cert_file and key_file must be set together")
+	}
+
+	if c.Middleware.CompressionMinBytes < 0 {
+		return fmt.Errorf("invalid compression min bytes: %d", c.Middleware.CompressionMinBytes)
+	}
+
+	if c.Middleware.AdaptiveCompression && c.Middleware.AdaptiveCompressionMaxInFlight <= 0 {
+		return fmt.Errorf("invalid adaptive compression max in-flight: %d", c.Middleware.AdaptiveCompressionMaxInFlight)
+	}
+
+	for _, route := range c.Middleware.CORSRoutes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("invalid cors route path prefix: %q", route.PathPrefix)
+		}
+	}
+
+	for _, patterns := range [][]string{c.Middleware.PathAllowPatterns, c.Middleware.PathDenyPatterns} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+				return fmt.Errorf("invalid path pattern: %q", pattern)
+			}
+		}
+	}
+
+	if c.Proxy.BufferResponses && c.Proxy.BufferMaxBytes <= 0 {
+		return fmt.Errorf("invalid proxy buffer max bytes: %d", c.Proxy.BufferMaxBytes)
+	}
+
+	if c.Cache.SingleFlight && c.Cache.MaxFileSizeMB <= 0 {
+		return fmt.Errorf("invalid cache max file size: %d", c.Cache.MaxFileSizeMB)
+	}
+
+	for _, rule := range c.Cache.Rules {
+		if _, err := path.Match(rule.ContentType, ""); err != nil || rule.ContentType == "" {
+			return fmt.Errorf("invalid cache rule content type: %q", rule.ContentType)
+		}
+		if rule.TTL <= 0 || rule.MaxSize < 0 {
+			return fmt.Errorf("invalid cache rule for %s: ttl %v, max size %d", rule.ContentType, rule.TTL, rule.MaxSize)
+		}
+	}
+
+	for _, endpoint := range c.API.DisabledEndpoints {
+		switch endpoint {
+		case "hello", "info", "status":
+		default:
+			return fmt.Errorf("invalid disabled api endpoint: %q", endpoint)
+		}
+	}
+
+	for path, endpoint := range c.API.Custom {
+		if !strings.HasPrefix(path, "/api/") || strings.ContainsAny(path, " \t\r\n") {
+			return fmt.Errorf("invalid custom api path: %q", path)
+		}
+		if c.builtinAPIPath(path) {
+			return fmt.Errorf("custom api path %s conflicts with a built-in endpoint", path)
+		}
+		if endpoint.Status != 0 && (endpoint.Status < 200 || endpoint.Status > 599) {
+			return fmt.Errorf("invalid status for custom api path %s: %d", path, endpoint.Status)
+		}
+		if !json.Valid([]byte(endpoint.Body)) {
+			return fmt.Errorf("custom api path %s body is not valid json", path)
+		}
+	}
+
 	validLogLevels := map[string]bool{
 		"debug": true,
 		"info":  true,
@@ -91,5 +733,200 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log level: %s", c.Logging.Level)
 	}
 
+	if c.Logging.AccessLogMaxSizeMB < 0 {
+		return fmt.Errorf("invalid access log max size: %d", c.Logging.AccessLogMaxSizeMB)
+	}
+
+	if _, err := c.LogLocation(); err != nil {
+		return fmt.Errorf("invalid log time zone: %w", err)
+	}
+
+	switch c.Logging.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid log format: %s", c.Logging.Format)
+	}
+
 	return nil
 }
+
+// Warnings lists settings that are valid but likely unintended, such as
+// timeouts left at zero which disable the protection entirely
+func (c *Config) Warnings() []string {
+	var warnings []string
+
+	switch strings.Trim(c.Server.Host, "[]") {
+	case "0.0.0.0", "::":
+		warnings = append(warnings, fmt.Sprintf("server host %s listens on every network interface", c.Server.Host))
+	}
+
+	if c.Server.ReadTimeout == 0 {
+		warnings = append(warnings, "server read_timeout is 0: request reads are unlimited")
+	}
+	if c.Server.WriteTimeout == 0 {
+		warnings = append(warnings, "server write_timeout is 0: response writes are unlimited")
+	}
+	if c.Server.IdleTimeout == 0 {
+		warnings = append(warnings, "server idle_timeout is 0: keep-alive connections fall back to read_timeout")
+	}
+	if len(c.TLS.CipherSuites) > 0 {
+		warnings = append(warnings, "tls cipher_suites only applies up to TLS 1.2: TLS 1.3 connections use the fixed crypto/tls suites")
+	}
+	if c.TLS.PreferServerCipherSuites {
+		warnings = append(warnings, "tls prefer_server_cipher_suites is deprecated: it is passed to crypto/tls, which ignores it since Go 1.18")
+	}
+	if c.ProxyEnabled() && c.Proxy.MaxRequestTimeout == 0 {
+		warnings = append(warnings, "proxy max_request_timeout is 0: client-supplied deadlines are not capped")
+	}
+
+	if !c.Middleware.EnableCompression {
+		if file, size, ok := largeTextAsset(c.Static.Directory); ok {
+			warnings = append(warnings, fmt.Sprintf(
+				"middleware enable_compression is off but static files such as %s (%d KB) would compress well", file, size>>10))
+		}
+	}
+
+	return warnings
+}
+
+// largeTextAssetBytes is the size from which an uncompressed text asset is
+// worth a warning
+const largeTextAssetBytes = 256 << 10
+
+// textAssetExtensions are the static file types that compress well
+var textAssetExtensions = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".mjs": true, ".json": true,
+	".map": true, ".svg": true, ".txt": true, ".xml": true,
+}
+
+// largeTextAsset returns the first text asset under dir of at least
+// largeTextAssetBytes, relative to dir
+func largeTextAsset(dir string) (string, int64, bool) {
+	var found string
+	var size int64
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !textAssetExtensions[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Size() >= largeTextAssetBytes {
+			found, size = p, info.Size()
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if found == "" {
+		return "", 0, false
+	}
+	if rel, err := filepath.Rel(dir, found); err == nil {
+		found = filepath.ToSlash(rel)
+	}
+	return found, size, true
+}
+
+// builtinAPIPath reports whether path is served by one of the built-in /api
+// routes. Disabled built-ins may be replaced by a custom endpoint.
+func (c *Config) builtinAPIPath(path string) bool {
+	switch path {
+	case "/api/readyz", "/api/drain", "/api/sri", "/api/tasks":
+		return true
+	case "/api/hello", "/api/info", "/api/status":
+		for _, name := range c.API.DisabledEndpoints {
+			if path == "/api/"+name {
+				return false
+			}
+		}
+		return true
+	}
+	return strings.HasPrefix(path, "/api/tasks/")
+}
+
+// TLSEnabled reports whether the server is configured to serve HTTPS
+func (c *Config) TLSEnabled() bool {
+	return (c.TLS.CertFile != "" && c.TLS.KeyFile != "") || c.AutoCertEnabled()
+}
+
+// ProxyEnabled reports whether /api/tasks is proxied. Unless Proxy.Enabled
+// is set, the proxy is on whenever a target is configured.
+func (c *Config) ProxyEnabled() bool {
+	if c.Proxy.Enabled != nil {
+		return *c.Proxy.Enabled
+	}
+	return c.Proxy.Target != "" || len(c.Proxy.Targets) > 0
+}
+
+// AutoCertEnabled reports whether certificates are obtained automatically via ACME
+func (c *Config) AutoCertEnabled() bool {
+	return len(c.TLS.AutoCert.Domains) > 0
+}
+
+// CipherSuiteIDs resolves TLS.CipherSuites to crypto/tls IDs. Only the
+// suites crypto/tls considers secure are accepted.
+func (c *Config) CipherSuiteIDs() ([]uint16, error) {
+	if len(c.TLS.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(c.TLS.CipherSuites))
+	for _, name := range c.TLS.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure tls cipher suite: %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	// net/http refuses to serve HTTP/2 without one of these
+	if !slices.Contains(ids, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!slices.Contains(ids, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return nil, fmt.Errorf("tls cipher_suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, which HTTP/2 requires")
+	}
+	return ids, nil
+}
+
+// LogLocation returns the time zone log timestamps are rendered in. An empty
+// Logging.TimeZone means local time.
+func (c *Config) LogLocation() (*time.Location, error) {
+	if c.Logging.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Logging.TimeZone)
+}
+
+// validHost accepts hostnames and IPv4 addresses as well as IPv6 literals,
+// with or without brackets (e.g. "::1", "[::1]", "fe80::1%eth0")
+func validHost(host string) bool {
+	inner := host
+	if strings.HasPrefix(host, "[") || strings.HasSuffix(host, "]") {
+		trimmed, ok := strings.CutPrefix(host, "[")
+		if !ok {
+			return false
+		}
+		if inner, ok = strings.CutSuffix(trimmed, "]"); !ok {
+			return false
+		}
+	} else if !strings.Contains(host, ":") {
+		return true
+	}
+
+	addr, _, _ := strings.Cut(inner, "%")
+	return strings.Contains(addr, ":") && net.ParseIP(addr) != nil
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name
+// (an RFC 9110 token)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package config
+
+import "fmt"
+
+// ErrConfigNotFound is returned when a config file, such as an included one,
+// does not exist. A missing top-level file is not an error: Load falls back
+// to the defaults.
+type ErrConfigNotFound struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrConfigNotFound) Error() string {
+	return fmt.Sprintf("config file not found: %s", e.Path)
+}
+
+func (e *ErrConfigNotFound) Unwrap() error { return e.Err }
+
+// ErrConfigParse is returned when a config file cannot be decoded or its
+// includes are malformed
+type ErrConfigParse struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrConfigParse) Error() string {
+	return fmt.Sprintf("failed to parse config file %s: %v", e.Path, e.Err)
+}
+
+func (e *ErrConfigParse) Unwrap() error { return e.Err }
+
+// ErrConfigInvalid is returned by Validate when a setting is out of range or
+// inconsistent with another one
+type ErrConfigInvalid struct {
+	Err error
+}
+
+func (e *ErrConfigInvalid) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ErrConfigInvalid) Unwrap() error { return e.Err }
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretRef matches ${file:/path} and ${env:NAME} references in string values
+var secretRef = regexp.MustCompile(`\$\{(file|env):([^}]*)\}`)
+
+// resolveSecrets replaces secret references in every string value under node
+// so secrets can live outside the YAML. Files are read whole, minus trailing
+// newlines; relative paths are resolved against baseDir.
+func resolveSecrets(node *yaml.Node, baseDir string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		// Only values are resolved, never keys
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := resolveSecrets(node.Content[i], baseDir); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!str" || !strings.Contains(node.Value, "${") {
+			return nil
+		}
+
+		var resolveErr error
+		node.Value = secretRef.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			m := secretRef.FindStringSubmatch(ref)
+			value, err := resolveSecret(m[1], m[2], baseDir)
+			if err != nil && resolveErr == nil {
+				resolveErr = fmt.Errorf("line %d: %w", node.Line, err)
+			}
+			return value
+		})
+		return resolveErr
+
+	default:
+		for _, child := range node.Content {
+			if err := resolveSecrets(child, baseDir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// resolveSecret looks up a single ${source:name} reference
+func resolveSecret(source, name, baseDir string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty ${%s:} reference", source)
+	}
+
+	if source == "env" {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	}
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(baseDir, name)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
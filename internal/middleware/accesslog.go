@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogPresets maps named presets to their token templates
+var accessLogPresets = map[string]string{
+	"common":   `%{remote} - - [%{time}] "%{request}" %{status} %{bytes}`,
+	"combined": `%{remote} - - [%{time}] "%{request}" %{status} %{bytes} "%{referer}" "%{user_agent}"`,
+}
+
+// accessLogEntry holds everything known about a finished request
+type accessLogEntry struct {
+	request  *http.Request
+	start    time.Time
+	status   int
+	bytes    int64
+	duration time.Duration
+
+	formatTime  func(time.Time) string
+	queryFilter QueryFilter
+}
+
+// accessLogTokens renders the value of each supported %{token}
+var accessLogTokens = map[string]func(e *accessLogEntry) string{
+	"remote": func(e *accessLogEntry) string {
+		if host, _, err := net.SplitHostPort(e.request.RemoteAddr); err == nil {
+			return host
+		}
+		return e.request.RemoteAddr
+	},
+	"time": func(e *accessLogEntry) string {
+		if e.formatTime != nil {
+			return e.formatTime(e.start)
+		}
+		return e.start.Format("02/Jan/2006:15:04:05 -0700")
+	},
+	"method": func(e *accessLogEntry) string { return e.request.Method },
+	"path":   func(e *accessLogEntry) string { return e.request.URL.Path },
+	"uri":    func(e *accessLogEntry) string { return e.queryFilter.RequestURI(e.request) },
+	"proto":  func(e *accessLogEntry) string { return e.request.Proto },
+	"request": func(e *accessLogEntry) string {
+		return e.request.Method + " " + e.queryFilter.RequestURI(e.request) + " " + e.request.Proto
+	},
+	"status":   func(e *accessLogEntry) string { return strconv.Itoa(e.status) },
+	"bytes":    func(e *accessLogEntry) string { return strconv.FormatInt(e.bytes, 10) },
+	"duration": func(e *accessLogEntry) string { return e.duration.String() },
+	"upstream_duration": func(e *accessLogEntry) string {
+		if d, ok := upstreamDuration(e.request.Context()); ok {
+			return d.String()
+		}
+		return "-"
+	},
+	"referer":    func(e *accessLogEntry) string { return dashIfEmpty(e.request.Referer()) },
+	"user_agent": func(e *accessLogEntry) string { return dashIfEmpty(e.request.UserAgent()) },
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// AccessLogFormat is a parsed access log template
+type AccessLogFormat struct {
+	literals     []string
+	tokens       []string
+	formatTime   func(time.Time) string
+	queryFilter  QueryFilter
+	statusFilter StatusFilter
+}
+
+// SetTimeFormatter overrides how %{time} is rendered; the default is the
+// Common Log Format timestamp in local time
+func (f *AccessLogFormat) SetTimeFormatter(fn func(time.Time) string) {
+	f.formatTime = fn
+}
+
+// SetQueryFilter controls how the query string appears in %{uri} and
+// %{request}
+func (f *AccessLogFormat) SetQueryFilter(filter QueryFilter) {
+	f.queryFilter = filter
+}
+
+// SetStatusFilter limits logging to responses whose status matches filter
+func (f *AccessLogFormat) SetStatusFilter(filter StatusFilter) {
+	f.statusFilter = filter
+}
+
+// ParseAccessLogFormat parses a preset name ("common", "combined") or a custom
+// template containing %{token} placeholders
+func ParseAccessLogFormat(format string) (*AccessLogFormat, error) {
+	if preset, ok := accessLogPresets[format]; ok {
+		format = preset
+	}
+
+	f := &AccessLogFormat{}
+	rest := format
+	for {
+		start := strings.Index(rest, "%{")
+		if start < 0 {
+			f.literals = append(f.literals, rest)
+			return f, nil
+		}
+
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated token in access log format %q", format)
+		}
+
+		token := rest[start+2 : start+end]
+		if _, ok := accessLogTokens[token]; !ok {
+			return nil, fmt.Errorf("unknown access log token %%{%s}", token)
+		}
+
+		f.literals = append(f.literals, rest[:start])
+		f.tokens = append(f.tokens, token)
+		rest = rest[start+end+1:]
+	}
+}
+
+// render formats a single log line for the given entry
+func (f *AccessLogFormat) render(e *accessLogEntry) string {
+	var b strings.Builder
+	for i, literal := range f.literals {
+		b.WriteString(literal)
+		if i < len(f.tokens) {
+			b.WriteString(accessLogTokens[f.tokens[i]](e))
+		}
+	}
+	return b.String()
+}
+
+// AccessLog middleware writes one line per request to out, rendered with format
+func AccessLog(format *AccessLogFormat, out io.Writer) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(wrappedWriter, r)
+
+			if !format.statusFilter.Match(wrappedWriter.statusCode) {
+				return
+			}
+
+			line := format.render(&accessLogEntry{
+				request:  r,
+				start:    start,
+				status:   wrappedWriter.statusCode,
+				bytes:    wrappedWriter.bytes,
+				duration: time.Since(start),
+
+				formatTime:  format.formatTime,
+				queryFilter: format.queryFilter,
+			})
+
+			mu.Lock()
+			io.WriteString(out, line+"\n")
+			mu.Unlock()
+		})
+	}
+}
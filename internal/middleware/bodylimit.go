@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// bodyLimitKey stores the over-limit flag in the request context
+type bodyLimitKey struct{}
+
+// MaxRequestBody middleware caps request bodies at limit bytes. Bodies are
+// still streamed: a declared Content-Length over the limit is rejected with
+// 413 up front, while chunked bodies fail mid-read with *http.MaxBytesError
+// once the limit is crossed (see BodyTooLarge).
+func MaxRequestBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > limit {
+				w.Header().Set("Connection", "close")
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			tooLarge := &atomic.Bool{}
+			r = r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, tooLarge))
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), tooLarge: tooLarge}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyTooLarge reports whether reading the request body hit its size limit
+func BodyTooLarge(r *http.Request) bool {
+	tooLarge, ok := r.Context().Value(bodyLimitKey{}).(*atomic.Bool)
+	return ok && tooLarge.Load()
+}
+
+// limitedBody records when the wrapped MaxBytesReader gives up, since the
+// error may not survive the trip through a proxy transport
+type limitedBody struct {
+	io.ReadCloser
+	tooLarge *atomic.Bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.tooLarge.Store(true)
+	}
+	return n, err
+}
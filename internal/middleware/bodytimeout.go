@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBodyReadTimeout is returned when a client takes too long to send its body
+var ErrBodyReadTimeout = errors.New("request body read timeout")
+
+// bodyTimeoutKey stores the timed-out flag in the request context
+type bodyTimeoutKey struct{}
+
+// BodyReadTimeout middleware cuts off clients that trickle their request body.
+// Reads fail with ErrBodyReadTimeout once timeout has elapsed since the
+// request started; handlers should answer with 408 (see BodyReadTimedOut).
+func BodyReadTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			deadline := time.Now().Add(timeout)
+
+			// Unblock a Read that is waiting on the socket; unsupported writers
+			// (e.g. in tests) still get the check between reads
+			http.NewResponseController(w).SetReadDeadline(deadline)
+
+			timedOut := &atomic.Bool{}
+			r = r.WithContext(context.WithValue(r.Context(), bodyTimeoutKey{}, timedOut))
+			r.Body = &timedBody{ReadCloser: r.Body, deadline: deadline, timedOut: timedOut}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyReadTimedOut reports whether the request body hit its read deadline
+func BodyReadTimedOut(r *http.Request) bool {
+	timedOut, ok := r.Context().Value(bodyTimeoutKey{}).(*atomic.Bool)
+	return ok && timedOut.Load()
+}
+
+// timedBody fails reads that happen after the deadline
+type timedBody struct {
+	io.ReadCloser
+	deadline time.Time
+	timedOut *atomic.Bool
+}
+
+// Read returns ErrBodyReadTimeout once the deadline has passed
+func (b *timedBody) Read(p []byte) (int, error) {
+	if time.Now().After(b.deadline) {
+		b.timedOut.Store(true)
+		return 0, ErrBodyReadTimeout
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		b.timedOut.Store(true)
+		return n, ErrBodyReadTimeout
+	}
+	return n, err
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// NoCompressionHeader lets a client ask for an uncompressed response
+const NoCompressionHeader = "X-No-Compression"
+
+// Compress middleware gzips compressible responses for clients that accept it.
+// Requests carrying ?nocompress=1 or the X-No-Compression header are served
+// raw, which helps when debugging minified or compressed assets.
+func Compress(next http.Handler) http.Handler {
+	return CompressMinSize(0)(next)
+}
+
+// CompressMinSize is like Compress but leaves responses smaller than minBytes
+// uncompressed. Bodies of unknown length are buffered until minBytes have
+// been written; a flush before that sends them uncompressed.
+func CompressMinSize(minBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if !AcceptsGzip(r) || compressionBypassed(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: minBytes}
+			next.ServeHTTP(gw, r)
+
+			// Not deferred: an aborted handler must not get a gzip trailer
+			// appended to its truncated body
+			gw.Close()
+		})
+	}
+}
+
+// AdaptiveCompress is like CompressMinSize but sends responses uncompressed
+// while more than maxInFlight requests are being handled, so a busy host
+// spends its CPU on serving rather than on gzip
+func AdaptiveCompress(minBytes, maxInFlight int) func(http.Handler) http.Handler {
+	var inFlight atomic.Int64
+
+	return func(next http.Handler) http.Handler {
+		compressed := CompressMinSize(minBytes)(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			if n > int64(maxInFlight) {
+				w.Header().Add("Vary", "Accept-Encoding")
+				next.ServeHTTP(w, r)
+				return
+			}
+			compressed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AcceptsGzip reports whether the client advertised gzip support
+func AcceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// compressionBypassed reports whether the request opted out of compression
+func compressionBypassed(r *http.Request) bool {
+	return r.URL.Query().Get("nocompress") == "1" || r.Header.Get(NoCompressionHeader) != ""
+}
+
+// Compressible reports whether a content type benefits from gzip
+func Compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"application/xhtml+xml", "image/svg+xml", "application/wasm":
+		return true
+	}
+
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// gzipResponseWriter compresses the body once it knows the response qualifies
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+
+	// With a minimum size the decision may be pending until enough of the
+	// body has been buffered
+	minSize int
+	pending bool
+	status  int
+	buf     []byte
+}
+
+// WriteHeader decides whether to compress based on the final headers.
+// Informational responses such as a relayed 100 Continue pass straight
+// through and leave the decision to the final status.
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if code < http.StatusOK && !w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader || w.pending {
+		return
+	}
+
+	h := w.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified ||
+		code == http.StatusPartialContent || h.Get("Content-Encoding") != "" ||
+		!Compressible(h.Get("Content-Type")) {
+		w.writeHeader(code, false)
+		return
+	}
+
+	if w.minSize > 0 {
+		length, err := strconv.Atoi(h.Get("Content-Length"))
+		switch {
+		case err == nil && length < w.minSize:
+			w.writeHeader(code, false)
+			return
+		case err != nil:
+			// Unknown length: buffer until the threshold is reached
+			w.pending = true
+			w.status = code
+			return
+		}
+	}
+
+	w.writeHeader(code, true)
+}
+
+// writeHeader commits the status code, switching to gzip if compress is set
+func (w *gzipResponseWriter) writeHeader(code int, compress bool) {
+	w.wroteHeader = true
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		// Byte ranges of the identity body do not apply to the gzip stream,
+		// so a client must not resume a compressed download with Range
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// commitPending ends a pending decision and writes out the buffered body
+func (w *gzipResponseWriter) commitPending(compress bool) error {
+	w.pending = false
+	w.writeHeader(w.status, compress)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Write sniffs the content type if needed and writes through the compressor
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader && !w.pending {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.pending {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.minSize {
+			if err := w.commitPending(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush pushes buffered compressed data to the client. A body still below
+// the minimum size is sent uncompressed.
+func (w *gzipResponseWriter) Flush() {
+	if w.pending {
+		w.commitPending(false)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close finishes the gzip stream, or writes out a body that stayed below the
+// minimum size
+func (w *gzipResponseWriter) Close() error {
+	if w.pending {
+		return w.commitPending(false)
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+)
+
+// contextHeadersKey stores the validated header values in the request context
+type contextHeadersKey struct{}
+
+// ContextHeader is a request header copied into the request context, such as
+// a tenant ID. Values must match Pattern in full.
+type ContextHeader struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// ContextHeaders middleware validates the configured headers and stores their
+// values in the request context (see ContextHeaderValue). A header that is
+// repeated or does not match its pattern is rejected with 400 Bad Request;
+// absent headers are left out of the context.
+func ContextHeaders(headers []ContextHeader) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			values := make(map[string]string, len(headers))
+			for _, header := range headers {
+				found := r.Header.Values(header.Name)
+				if len(found) == 0 {
+					continue
+				}
+				if len(found) > 1 || !header.Pattern.MatchString(found[0]) {
+					http.Error(w, "Invalid "+http.CanonicalHeaderKey(header.Name)+" header", http.StatusBadRequest)
+					return
+				}
+				values[http.CanonicalHeaderKey(header.Name)] = found[0]
+			}
+
+			if len(values) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), contextHeadersKey{}, values))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ContextHeaderValue returns the validated value of a configured context
+// header, or "" when the request did not send it
+func ContextHeaderValue(ctx context.Context, name string) string {
+	values, _ := ctx.Value(contextHeadersKey{}).(map[string]string)
+	return values[http.CanonicalHeaderKey(name)]
+}
+
+// ContextHeaderValues returns every validated context header value by
+// canonical header name. The map must not be modified.
+func ContextHeaderValues(ctx context.Context) map[string]string {
+	values, _ := ctx.Value(contextHeadersKey{}).(map[string]string)
+	return values
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics holds concurrency-safe request counters
+type Metrics struct {
+	requests atomic.Int64
+	bytes    atomic.Int64
+	inFlight atomic.Int64
+	// statusClasses counts responses by class: index 1 is 1xx ... 5 is 5xx
+	statusClasses [6]atomic.Int64
+}
+
+// MetricsSnapshot is a point-in-time copy of the counters
+type MetricsSnapshot struct {
+	Requests  int64            `json:"total" yaml:"total"`
+	BytesSent int64            `json:"bytes_sent" yaml:"bytes_sent"`
+	InFlight  int64            `json:"in_flight" yaml:"in_flight"`
+	Status    map[string]int64 `json:"status" yaml:"status"`
+}
+
+// NewMetrics creates an empty set of counters
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Middleware counts every request passing through next
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrappedWriter, r)
+
+		m.requests.Add(1)
+		m.bytes.Add(wrappedWriter.bytes)
+		if class := wrappedWriter.statusCode / 100; class >= 1 && class <= 5 {
+			m.statusClasses[class].Add(1)
+		}
+	})
+}
+
+// Snapshot returns the current counter values
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Requests:  m.requests.Load(),
+		BytesSent: m.bytes.Load(),
+		InFlight:  m.inFlight.Load(),
+		Status: map[string]int64{
+			"2xx": m.statusClasses[2].Load(),
+			"3xx": m.statusClasses[3].Load(),
+			"4xx": m.statusClasses[4].Load(),
+			"5xx": m.statusClasses[5].Load(),
+		},
+	}
+}
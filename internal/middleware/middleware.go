@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -23,33 +27,147 @@ func Logger(next http.Handler) http.Handler {
 	})
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
+// RequestLogger middleware logs each HTTP request whose status matches status
+// through a structured logger. The query string, filtered by query, is logged
+// when non-empty.
+func RequestLogger(l *slog.Logger, query QueryFilter, status StatusFilter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(wrappedWriter, r)
+
+			if !status.Match(wrappedWriter.statusCode) {
+				return
+			}
+
+			attrs := []any{"method", r.Method, "path", r.URL.Path}
+			if rawQuery := query.Apply(r.URL.RawQuery); rawQuery != "" {
+				attrs = append(attrs, "query", rawQuery)
+			}
+			attrs = append(attrs,
+				"status", wrappedWriter.statusCode,
+				"bytes", wrappedWriter.bytes,
+				"duration", time.Since(start),
+			)
+			if upstream, ok := upstreamDuration(r.Context()); ok {
+				attrs = append(attrs, "upstream_duration", upstream)
+			}
+			l.Info("request", attrs...)
+		})
+	}
+}
+
+// responseWriter wraps http.ResponseWriter to capture status code and body size
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
-// WriteHeader captures the status code
+// WriteHeader captures the final status code; informational responses other
+// than 101 Switching Protocols are followed by another status
 func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
+	if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+		rw.statusCode = code
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// CORS middleware adds CORS headers
+// Flush forwards to the underlying writer so streaming responses still work
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Write counts the bytes written to the client
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// CORS middleware adds CORS headers allowing any origin
 func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	return CORSWithPolicies(CORSPolicy{}, nil)(next)
+}
+
+// CORSPolicy is the set of CORS headers sent for a group of routes. Empty
+// lists fall back to any origin, the common methods, and Content-Type and
+// Authorization headers.
+type CORSPolicy struct {
+	AllowOrigins []string
+	AllowMethods []string
+	AllowHeaders []string
+}
+
+// CORSRoute applies Policy to requests under PathPrefix
+type CORSRoute struct {
+	PathPrefix string
+	Policy     CORSPolicy
+}
+
+// CORSWithPolicies is like CORS but picks the policy of the longest route
+// prefix matching the request path, or def when none does. A prefix matches
+// whole path segments, so "/api" covers "/api/tasks" but not "/apidocs".
+func CORSWithPolicies(def CORSPolicy, routes []CORSRoute) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := def
+			matched := ""
+			for _, route := range routes {
+				prefix := strings.TrimSuffix(route.PathPrefix, "/")
+				if len(prefix) >= len(matched) && underPrefix(r.URL.Path, prefix) {
+					policy, matched = route.Policy, prefix
+				}
+			}
+			policy.apply(w, r)
+
+			// Handle preflight requests; plain OPTIONS requests carry no
+			// Access-Control-Request-Method and are left to the route
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apply sets the policy's headers. Listed origins are echoed back only to
+// matching requests, so other origins get no Access-Control-Allow-Origin.
+func (p CORSPolicy) apply(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+
+	origin := "*"
+	if len(p.AllowOrigins) > 0 && !slices.Contains(p.AllowOrigins, "*") {
+		h.Add("Vary", "Origin")
+		origin = r.Header.Get("Origin")
+		if origin == "" || !slices.Contains(p.AllowOrigins, origin) {
+			origin = ""
 		}
+	}
+	if origin != "" {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	methods, headers := "GET, POST, PUT, DELETE, OPTIONS", "Content-Type, Authorization"
+	if len(p.AllowMethods) > 0 {
+		methods = strings.Join(p.AllowMethods, ", ")
+	}
+	if len(p.AllowHeaders) > 0 {
+		headers = strings.Join(p.AllowHeaders, ", ")
+	}
+	h.Set("Access-Control-Allow-Methods", methods)
+	h.Set("Access-Control-Allow-Headers", headers)
 }
 
 // Security middleware adds basic security headers
@@ -58,7 +176,122 @@ func Security(next http.Handler) http.Handler {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ServerHeader middleware sets the Server response header to value, or
+// removes it when value is empty. The header is applied when the response is
+// committed so it also replaces any Server header copied from an upstream.
+func ServerHeader(value string) func(http.Handler) http.Handler {
+	return onCommit(func(h http.Header) {
+		if value == "" {
+			h.Del("Server")
+		} else {
+			h.Set("Server", value)
+		}
+	})
+}
+
+// RequiredHeaders middleware makes sure every response carries headers. They
+// are applied when the response is committed, so values a handler changed or
+// deleted are restored.
+func RequiredHeaders(headers map[string]string) func(http.Handler) http.Handler {
+	return onCommit(func(h http.Header) {
+		for name, value := range headers {
+			h.Set(name, value)
+		}
+	})
+}
+
+// onCommit returns middleware that calls apply on the response headers just
+// before the final status line is written
+func onCommit(apply func(http.Header)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&commitHeaderWriter{ResponseWriter: w, apply: apply}, r)
+		})
+	}
+}
+
+// commitHeaderWriter rewrites headers just before the final status line is
+// written
+type commitHeaderWriter struct {
+	http.ResponseWriter
+	apply       func(http.Header)
+	wroteHeader bool
+}
+
+func (w *commitHeaderWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.apply(w.Header())
+		// Informational responses may be followed by the final status
+		w.wroteHeader = code >= 200
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *commitHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer so streaming responses still work
+func (w *commitHeaderWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *commitHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// HSTS middleware adds the Strict-Transport-Security header. Browsers ignore
+// the header over plain HTTP, so it is only emitted for TLS requests.
+func HSTS(maxAge int, includeSubDomains, preload bool) func(http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", maxAge)
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaxURILength middleware rejects requests whose request URI (path and query)
+// is longer than limit bytes with 414 URI Too Long
+func MaxURILength(limit int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uri := r.RequestURI
+			if uri == "" {
+				uri = r.URL.RequestURI()
+			}
+
+			if len(uri) > limit {
+				http.Error(w, "URI Too Long", http.StatusRequestURITooLong)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
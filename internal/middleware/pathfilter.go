@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// MatchPathPattern reports whether urlPath matches a glob pattern as understood
+// by path.Match. A pattern ending in "/**" also matches everything below that
+// prefix, e.g. "/private/**" matches "/private/a/b.txt".
+func MatchPathPattern(pattern, urlPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+		if matched, _ := path.Match(prefix, urlPath); matched {
+			return true
+		}
+		for dir := path.Dir(urlPath); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if matched, _ := path.Match(prefix, dir); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	matched, _ := path.Match(pattern, urlPath)
+	return matched
+}
+
+// PathFilter middleware answers 403 for paths matching any deny pattern, and,
+// when allow patterns are given, for paths matching none of them. Paths are
+// cleaned first so "/a/../secret" cannot slip past a "/secret" rule.
+func PathFilter(allow, deny []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cleaned := path.Clean("/" + r.URL.Path)
+
+			for _, pattern := range deny {
+				if MatchPathPattern(pattern, cleaned) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			if len(allow) > 0 {
+				allowed := false
+				for _, pattern := range allow {
+					if MatchPathPattern(pattern, cleaned) {
+						allowed = true
+						break
+					}
+				}
+				if !allowed {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
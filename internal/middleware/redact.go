@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// QueryFilter controls how query strings appear in request logs. The zero
+// value logs them unchanged.
+type QueryFilter struct {
+	// Omit drops the query string entirely
+	Omit bool
+	// Redact lists parameter names whose values are replaced with ***
+	Redact []string
+}
+
+// Apply returns rawQuery as it should be logged. Parameter order and encoding
+// are preserved; only the values of redacted parameters change.
+func (f QueryFilter) Apply(rawQuery string) string {
+	if f.Omit {
+		return ""
+	}
+	if len(f.Redact) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		key, _, hasValue := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		for _, redacted := range f.Redact {
+			if name == redacted && hasValue {
+				pairs[i] = key + "=***"
+				break
+			}
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// RequestURI returns the request target with the query filtered
+func (f QueryFilter) RequestURI(r *http.Request) string {
+	uri, rawQuery, _ := strings.Cut(r.RequestURI, "?")
+	if query := f.Apply(rawQuery); query != "" {
+		return uri + "?" + query
+	}
+	return uri
+}
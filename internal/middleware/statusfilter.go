@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusFilter selects which response statuses are logged. The zero value
+// matches every status.
+type StatusFilter struct {
+	ranges []statusRange
+}
+
+// statusRange is an inclusive range of status codes
+type statusRange struct {
+	min, max int
+}
+
+// ParseStatusFilter parses status expressions: an exact code ("404"), a class
+// ("5xx"), an inclusive range ("500-599") or a comparison (">=400", "<300").
+// A status is matched when any expression matches it.
+func ParseStatusFilter(exprs []string) (StatusFilter, error) {
+	var f StatusFilter
+	for _, expr := range exprs {
+		r, err := parseStatusRange(strings.TrimSpace(expr))
+		if err != nil {
+			return StatusFilter{}, err
+		}
+		f.ranges = append(f.ranges, r)
+	}
+	return f, nil
+}
+
+func parseStatusRange(expr string) (statusRange, error) {
+	code := func(s string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 100 || n > 599 {
+			return 0, fmt.Errorf("invalid status filter %q", expr)
+		}
+		return n, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		rest, ok := strings.CutPrefix(expr, op)
+		if !ok {
+			continue
+		}
+		n, err := code(rest)
+		if err != nil {
+			return statusRange{}, err
+		}
+		switch op {
+		case ">=":
+			return statusRange{n, 599}, nil
+		case "<=":
+			return statusRange{100, n}, nil
+		case ">":
+			return statusRange{n + 1, 599}, nil
+		default:
+			return statusRange{100, n - 1}, nil
+		}
+	}
+
+	if class, ok := strings.CutSuffix(strings.ToLower(expr), "xx"); ok && len(class) == 1 {
+		n, err := code(class + "00")
+		if err != nil {
+			return statusRange{}, err
+		}
+		return statusRange{n, n + 99}, nil
+	}
+
+	if lo, hi, ok := strings.Cut(expr, "-"); ok {
+		min, err := code(lo)
+		if err != nil {
+			return statusRange{}, err
+		}
+		max, err := code(hi)
+		if err != nil || max < min {
+			return statusRange{}, fmt.Errorf("invalid status filter %q", expr)
+		}
+		return statusRange{min, max}, nil
+	}
+
+	n, err := code(expr)
+	if err != nil {
+		return statusRange{}, err
+	}
+	return statusRange{n, n}, nil
+}
+
+// Match reports whether responses with status code should be logged
+func (f StatusFilter) Match(code int) bool {
+	if len(f.ranges) == 0 {
+		return true
+	}
+	for _, r := range f.ranges {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteTimeout overrides the default request timeout for paths under
+// PathPrefix. A zero Timeout lets those requests run without a limit.
+type RouteTimeout struct {
+	PathPrefix string
+	Timeout    time.Duration
+}
+
+// Timeout middleware cancels the request context once the timeout of the
+// longest matching route prefix (or def when none matches) has passed. If the
+// handler has not started its response by then, the client gets a JSON 504
+// and anything the handler writes afterwards is discarded; a response already
+// streaming is simply cut short by the canceled context. WebSocket upgrades
+// and text/event-stream responses, which are meant to stay open, are never
+// timed out.
+func Timeout(def time.Duration, routes []RouteTimeout) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := def
+			matched := ""
+			for _, route := range routes {
+				prefix := strings.TrimSuffix(route.PathPrefix, "/")
+				if len(prefix) >= len(matched) && underPrefix(r.URL.Path, prefix) {
+					timeout, matched = route.Timeout, prefix
+				}
+			}
+			if timeout <= 0 || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			// The 504 is committed under mu before the handler's context is
+			// canceled, so a handler reacting to the cancellation can never
+			// get its late response in first
+			tw := &timeoutWriter{w: w, header: make(http.Header)}
+			fired := make(chan struct{})
+			timer := time.AfterFunc(timeout, func() {
+				tw.mu.Lock()
+				if tw.eventStream {
+					tw.mu.Unlock()
+					close(fired)
+					return
+				}
+				if !tw.wroteHeader {
+					tw.timedOut = true
+					writeTimeout(w, timeout)
+				}
+				tw.mu.Unlock()
+				cancel()
+				close(fired)
+			})
+			// stop waits out a timer callback that is already writing to w
+			stop := func() {
+				if !timer.Stop() {
+					<-fired
+				}
+			}
+
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case p := <-panicked:
+				stop()
+				panic(p)
+			case <-done:
+				stop()
+				return
+			case <-fired:
+			}
+
+			tw.mu.Lock()
+			timedOut := tw.timedOut
+			tw.mu.Unlock()
+			if timedOut {
+				return
+			}
+
+			// The response is already on its way and the handler still owns
+			// the writer until it notices the cancellation
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			}
+		})
+	}
+}
+
+// underPrefix reports whether urlPath is prefix or lies below it, matching
+// whole path segments
+func underPrefix(urlPath, prefix string) bool {
+	return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// writeTimeout writes the JSON 504 sent when a request runs out of time
+func writeTimeout(w http.ResponseWriter, timeout time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "gateway timeout",
+		"status":  http.StatusGatewayTimeout,
+		"timeout": timeout.String(),
+	})
+}
+
+// timeoutWriter hands the handler its own header map until the response
+// starts, so a timeout can still replace the response, and drops writes once
+// the timeout response has been sent
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+	eventStream bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	// Trailers are set on the real header after the body
+	if tw.wroteHeader {
+		return tw.w.Header()
+	}
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(code)
+}
+
+// writeHeader copies the handler's headers over and commits the status; the
+// caller holds mu
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	dst := tw.w.Header()
+	for name, values := range tw.header {
+		dst[name] = values
+	}
+	tw.w.WriteHeader(code)
+
+	if code < http.StatusOK {
+		// Headers of an informational response are not kept for the final
+		// one, just as the handler clears its own map
+		for name := range tw.header {
+			dst.Del(name)
+		}
+		return
+	}
+	tw.wroteHeader = true
+	mediaType, _, _ := mime.ParseMediaType(dst.Get("Content-Type"))
+	tw.eventStream = mediaType == "text/event-stream"
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// Flush forwards to the underlying writer so streaming responses still work
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
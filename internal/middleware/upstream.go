@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upstreamTimingKey stores the request's upstreamTiming in its context
+type upstreamTimingKey struct{}
+
+// upstreamTiming holds how long the backend took to answer a proxied request.
+// A handler abandoned by Timeout may still record while the logger reads.
+type upstreamTiming struct {
+	mu       sync.Mutex
+	duration time.Duration
+	recorded bool
+}
+
+// TrackUpstream middleware makes room in the request context for the proxy
+// to record its upstream duration (see RecordUpstream), which RequestLogger
+// then logs as upstream_duration next to the total duration and AccessLog
+// renders for %{upstream_duration}. It must wrap the logger.
+func TrackUpstream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), upstreamTimingKey{}, &upstreamTiming{})))
+	})
+}
+
+// RecordUpstream records d as the upstream duration of the request owning
+// ctx. Only the first call counts, and it is a no-op without TrackUpstream.
+func RecordUpstream(ctx context.Context, d time.Duration) {
+	timing, ok := ctx.Value(upstreamTimingKey{}).(*upstreamTiming)
+	if !ok {
+		return
+	}
+
+	timing.mu.Lock()
+	defer timing.mu.Unlock()
+	if !timing.recorded {
+		timing.duration, timing.recorded = d, true
+	}
+}
+
+// upstreamDuration returns the duration recorded by RecordUpstream
+func upstreamDuration(ctx context.Context) (time.Duration, bool) {
+	timing, ok := ctx.Value(upstreamTimingKey{}).(*upstreamTiming)
+	if !ok {
+		return 0, false
+	}
+
+	timing.mu.Lock()
+	defer timing.mu.Unlock()
+	return timing.duration, timing.recorded
+}
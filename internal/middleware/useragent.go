@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// CompileUserAgentPatterns turns a user agent denylist into matchers. Entries
+// wrapped in slashes ("/bot-[0-9]+/") are regular expressions, anything else
+// is matched as a case-insensitive substring.
+func CompileUserAgentPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := "(?i)" + regexp.QuoteMeta(pattern)
+		if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked user agent pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// BlockUserAgents middleware rejects requests whose User-Agent matches any of
+// the patterns with 403 Forbidden
+func BlockUserAgents(patterns []*regexp.Regexp) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgent := r.UserAgent()
+			for _, re := range patterns {
+				if re.MatchString(userAgent) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net"
+	"net/http"
+)
+
+// setupAdmin builds the admin server exposing status and readiness endpoints
+// on Server.AdminAddr, separate from public traffic
+func (s *Server) setupAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", s.handleStatus)
+	mux.HandleFunc("/api/info", s.handleInfo)
+	mux.HandleFunc("/api/readyz", s.handleReadyz)
+	mux.HandleFunc("/api/drain", s.handleDrain)
+	if s.config.Server.EnableExpvar {
+		mux.HandleFunc("/debug/vars", s.handleExpvar)
+	}
+
+	s.adminServer = &http.Server{
+		Addr:         s.config.Server.AdminAddr,
+		Handler:      mux,
+		ReadTimeout:  s.config.Server.ReadTimeout,
+		WriteTimeout: s.config.Server.WriteTimeout,
+		IdleTimeout:  s.config.Server.IdleTimeout,
+	}
+}
+
+// startAdmin binds (or inherits) the admin listener and serves it in the
+// background
+func (s *Server) startAdmin() error {
+	ln, err := listenOrInherit(adminListenerFDEnv, s.adminServer.Addr, net.ListenConfig{})
+	if err != nil {
+		return err
+	}
+	s.adminListener = ln
+
+	s.logger.Info("admin endpoints listening", "address", ln.Addr().String())
+
+	go func() {
+		if err := s.adminServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("admin server failed", "error", err)
+		}
+	}()
+	return nil
+}
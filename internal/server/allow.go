@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+)
+
+const (
+	// readOnlyMethods are accepted by the built-in API endpoints and static files
+	readOnlyMethods = "GET, HEAD, OPTIONS"
+
+	// allMethods are assumed for the proxy and for custom handlers, which
+	// decide for themselves what they accept
+	allMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+)
+
+// allowedMethods returns the Allow header value for a registered mux pattern
+func allowedMethods(pattern string) string {
+	switch pattern {
+	case "/api/drain":
+		return "POST, OPTIONS"
+	case "/api/hello", "/api/status", "/api/info", "/api/readyz", "/api/sri", "/debug/vars",
+		"/robots.txt", "/.well-known/security.txt", "/sitemap.xml", "/":
+		return readOnlyMethods
+	default:
+		return allMethods
+	}
+}
+
+// handleOptions answers OPTIONS requests for every route with 204 and the
+// methods the matched route supports, instead of passing them on to the file
+// server or proxy. CORS preflights are answered earlier by the CORS middleware.
+func (s *Server) handleOptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, pattern := s.mux.Handler(r)
+		w.Header().Set("Allow", allowedMethods(pattern))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
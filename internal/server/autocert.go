@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS applies the cipher suite settings to the TLS listener. The
+// suites are validated by config.Validate.
+func (s *Server) configureTLS() {
+	tlsCfg := s.config.TLS
+	if len(tlsCfg.CipherSuites) == 0 && !tlsCfg.PreferServerCipherSuites {
+		return
+	}
+
+	if s.httpServer.TLSConfig == nil {
+		s.httpServer.TLSConfig = &tls.Config{}
+	}
+	s.httpServer.TLSConfig.CipherSuites, _ = s.config.CipherSuiteIDs()
+	s.httpServer.TLSConfig.PreferServerCipherSuites = tlsCfg.PreferServerCipherSuites
+}
+
+// setupAutoCert configures Let's Encrypt certificates for the TLS listener and
+// the plain HTTP server answering HTTP-01 challenges
+func (s *Server) setupAutoCert() {
+	acme := s.config.TLS.AutoCert
+
+	s.autocertManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(acme.Domains...),
+		Cache:      autocert.DirCache(acme.CacheDir),
+		Email:      acme.Email,
+	}
+
+	s.httpServer.TLSConfig = s.autocertManager.TLSConfig()
+
+	// Challenge requests are answered by the manager, everything else is
+	// redirected to HTTPS
+	s.challengeServer = &http.Server{
+		Addr:         acme.HTTPAddr,
+		Handler:      s.autocertManager.HTTPHandler(nil),
+		ReadTimeout:  s.config.Server.ReadTimeout,
+		WriteTimeout: s.config.Server.WriteTimeout,
+		IdleTimeout:  s.config.Server.IdleTimeout,
+	}
+}
+
+// startChallenge binds (or inherits) the ACME challenge listener and serves
+// it in the background. Failures are logged, as certificates can still be
+// obtained through TLS-ALPN.
+func (s *Server) startChallenge() {
+	ln, err := listenOrInherit(challengeListenerFDEnv, s.challengeServer.Addr, net.ListenConfig{})
+	if err != nil {
+		s.logger.Error("ACME challenge server failed", "error", err)
+		return
+	}
+	s.challengeListener = ln
+
+	go func() {
+		if err := s.challengeServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("ACME challenge server failed", "error", err)
+		}
+	}()
+}
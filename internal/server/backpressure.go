@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// errBackendSaturated is returned when a backend already has the maximum
+// number of proxied requests in flight
+var errBackendSaturated = errors.New("backend at max concurrent requests")
+
+// backendLimiter caps the in-flight requests sent to each backend host with
+// a semaphore per host. Requests over the cap fail fast with
+// errBackendSaturated rather than queue, so an overloaded backend sheds load.
+type backendLimiter struct {
+	http.RoundTripper
+	max int
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+func newBackendLimiter(next http.RoundTripper, max int) *backendLimiter {
+	return &backendLimiter{RoundTripper: next, max: max, sems: make(map[string]chan struct{})}
+}
+
+func (l *backendLimiter) semaphore(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sem, ok := l.sems[host]
+	if !ok {
+		sem = make(chan struct{}, l.max)
+		l.sems[host] = sem
+	}
+	return sem
+}
+
+// RoundTrip holds a slot for the backend until the response body is closed,
+// so streamed responses keep counting against the cap
+func (l *backendLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	sem := l.semaphore(req.URL.Host)
+	select {
+	case sem <- struct{}{}:
+	default:
+		return nil, errBackendSaturated
+	}
+
+	var once sync.Once
+	release := func() { once.Do(func() { <-sem }) }
+
+	resp, err := l.RoundTripper.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// Upgraded connections must stay writable for the proxy to relay them
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok {
+		resp.Body = &releasingConn{ReadWriteCloser: rwc, release: release}
+	} else {
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	}
+	return resp, nil
+}
+
+// releasingBody frees its backend slot when closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// releasingConn is releasingBody for upgraded (101) connections
+type releasingConn struct {
+	io.ReadWriteCloser
+	release func()
+}
+
+func (c *releasingConn) Close() error {
+	defer c.release()
+	return c.ReadWriteCloser.Close()
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+
+	"github.com/featherjet/featherjet/internal/config"
+)
+
+// weightedBackend is one proxy target with its smooth weighted round-robin
+// state
+type weightedBackend struct {
+	url      *url.URL
+	weight   int
+	current  int
+	director func(*http.Request)
+}
+
+// weightedBalancer spreads requests across backends in proportion to their
+// weights using smooth weighted round-robin, which interleaves picks instead
+// of sending bursts to the heaviest backend
+type weightedBalancer struct {
+	mu       sync.Mutex
+	backends []*weightedBackend
+}
+
+// newWeightedBalancer builds a balancer over validated proxy targets
+func newWeightedBalancer(targets []config.ProxyTarget) *weightedBalancer {
+	b := &weightedBalancer{}
+	for _, target := range targets {
+		// Targets are validated by config.Validate
+		u, _ := url.Parse(target.URL)
+		weight := target.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		b.backends = append(b.backends, &weightedBackend{
+			url:      u,
+			weight:   weight,
+			director: httputil.NewSingleHostReverseProxy(u).Director,
+		})
+	}
+	return b
+}
+
+// next picks the backend for the following request
+func (b *weightedBalancer) next() *weightedBackend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *weightedBackend
+	total := 0
+	for _, backend := range b.backends {
+		backend.current += backend.weight
+		total += backend.weight
+		if best == nil || backend.current > best.current {
+			best = backend
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// director routes a request to the next backend
+func (b *weightedBalancer) director(req *http.Request) {
+	b.next().director(req)
+}
+
+// weights reports each backend with its effective weight for /api/info
+func (b *weightedBalancer) weights() []map[string]interface{} {
+	weights := make([]map[string]interface{}, 0, len(b.backends))
+	for _, backend := range b.backends {
+		weights = append(weights, map[string]interface{}{
+			"url":    backend.url.String(),
+			"weight": backend.weight,
+		})
+	}
+	return weights
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/featherjet/featherjet/internal/config"
+)
+
+// cachePolicy is the snapshot of static cache settings the file handler
+// reads on every request. It is swapped atomically on reload.
+type cachePolicy struct {
+	maxAge    string
+	immutable *regexp.Regexp
+}
+
+// newCachePolicy captures the cache settings of a validated config
+func newCachePolicy(cfg *config.Config) *cachePolicy {
+	policy := &cachePolicy{maxAge: cfg.Static.CacheMaxAge}
+	if cfg.Static.ImmutablePattern != "" {
+		policy.immutable = regexp.MustCompile(cfg.Static.ImmutablePattern)
+	}
+	return policy
+}
+
+// ReloadCacheHeaders applies the static cache settings from cfg to requests
+// served from now on, without rebuilding the server
+func (s *Server) ReloadCacheHeaders(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s.cachePolicy.Store(newCachePolicy(cfg))
+	s.logger.Info("static cache headers reloaded", "cache_max_age", cfg.Static.CacheMaxAge)
+	return nil
+}
+
+// cacheControlFor picks the Cache-Control policy for a static path.
+// Fingerprinted assets never change and are cached for a year, HTML is
+// always revalidated and everything else falls back to CacheMaxAge.
+func (s *Server) cacheControlFor(urlPath string) string {
+	policy := s.cachePolicy.Load()
+
+	name := path.Base(urlPath)
+	if policy.immutable != nil && policy.immutable.MatchString(name) {
+		return "public, max-age=31536000, immutable"
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		// Directory requests resolve to index.html
+		return "no-cache"
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+		return "no-cache"
+	}
+
+	if policy.maxAge != "" {
+		return "max-age=" + policy.maxAge
+	}
+
+	return ""
+}
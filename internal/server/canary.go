@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// canaryHeader lets trusted internal clients pick an allowlisted backend for
+// a single request
+const canaryHeader = "X-FJ-Canary-Backend"
+
+// canaryKey carries the chosen canary director in the request context
+type canaryKey struct{}
+
+// canaryDirector wraps the regular director so requests routed by
+// routeCanary go to their canary backend instead
+func (s *Server) canaryDirector(next func(*http.Request)) func(*http.Request) {
+	s.canaryBackends = make(map[string]func(*http.Request), len(s.config.Proxy.CanaryBackends))
+	for _, backend := range s.config.Proxy.CanaryBackends {
+		// Backends are validated by config.Validate
+		u, _ := url.Parse(backend)
+		s.canaryBackends[strings.TrimSuffix(backend, "/")] = httputil.NewSingleHostReverseProxy(u).Director
+	}
+
+	return func(req *http.Request) {
+		if director, ok := req.Context().Value(canaryKey{}).(func(*http.Request)); ok {
+			director(req)
+			return
+		}
+		next(req)
+	}
+}
+
+// routeCanary strips the canary header and, when it comes from a client in
+// Proxy.CanaryAllowCIDRs, routes r to the requested backend. It reports false
+// for a trusted request naming a backend outside Proxy.CanaryBackends.
+func (s *Server) routeCanary(r *http.Request) (*http.Request, bool) {
+	backend := r.Header.Get(canaryHeader)
+	if backend == "" {
+		return r, true
+	}
+	r.Header.Del(canaryHeader)
+
+	// Untrusted clients cannot steer traffic; the header is simply dropped
+	if !addrInNets(r.RemoteAddr, s.canaryAllow) {
+		return r, true
+	}
+
+	director, ok := s.canaryBackends[strings.TrimSuffix(backend, "/")]
+	if !ok {
+		s.logger.Warn("rejected canary backend", "backend", backend, "remote_addr", r.RemoteAddr)
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), canaryKey{}, director)), true
+}
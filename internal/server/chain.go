@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/featherjet/featherjet/internal/config"
+	"github.com/featherjet/featherjet/internal/middleware"
+)
+
+// namedMiddleware is one named link in the request handling chain
+type namedMiddleware struct {
+	name string
+	wrap func(http.Handler) http.Handler
+}
+
+// middlewareChain lists the middleware enabled by the config, outermost
+// first: a request passes through them in this order before reaching the mux
+func (s *Server) middlewareChain() []namedMiddleware {
+	var chain []namedMiddleware
+	add := func(name string, wrap func(http.Handler) http.Handler) {
+		chain = append(chain, namedMiddleware{name: name, wrap: wrap})
+	}
+
+	// Count every request for /api/status
+	add("metrics", s.metrics.Middleware)
+
+	// Report handler time to browsers
+	if s.config.Server.EmitServerTiming {
+		add("server_timing", s.serverTiming)
+	}
+
+	// Let the proxy report backend time to the logger
+	if logging := s.config.Logging; logging.EnableRequestLogging && logging.LogUpstreamTiming {
+		add("upstream_timing", middleware.TrackUpstream)
+	}
+
+	// Add request logging if enabled
+	if s.config.Logging.EnableRequestLogging {
+		add("logger", s.requestLogger())
+	}
+
+	// Restore operator-mandated headers that a handler dropped or changed
+	if headers := s.config.Security.RequiredHeaders; len(headers) > 0 {
+		add("required_headers", middleware.RequiredHeaders(headers))
+	}
+
+	// Brand or suppress the Server header on every response
+	add("server_header", middleware.ServerHeader(s.config.Server.ServerHeader))
+
+	// Reject abusive URIs before routing or proxying
+	if s.config.Server.MaxURILength > 0 {
+		add("max_uri_length", middleware.MaxURILength(s.config.Server.MaxURILength))
+	}
+
+	// Restrict which paths are reachable at all
+	if mw := s.config.Middleware; len(mw.PathAllowPatterns) > 0 || len(mw.PathDenyPatterns) > 0 {
+		add("path_filter", middleware.PathFilter(mw.PathAllowPatterns, mw.PathDenyPatterns))
+	}
+
+	// Serve the maintenance page to everyone but allowlisted operators
+	add("maintenance", s.maintenanceMiddleware)
+
+	// Add gzip compression if enabled, backing off under load in adaptive mode
+	if mw := s.config.Middleware; mw.EnableCompression && mw.AdaptiveCompression {
+		add("compression", middleware.AdaptiveCompress(mw.CompressionMinBytes, mw.AdaptiveCompressionMaxInFlight))
+	} else if mw.EnableCompression {
+		add("compression", middleware.CompressMinSize(mw.CompressionMinBytes))
+	}
+
+	// Cap request bodies without buffering them
+	if limit := s.config.Server.MaxRequestBodyBytes; limit > 0 {
+		add("max_request_body", middleware.MaxRequestBody(limit))
+	}
+
+	// Turn away denylisted bots and scrapers
+	if len(s.blockedUserAgents) > 0 {
+		add("block_user_agents", middleware.BlockUserAgents(s.blockedUserAgents))
+	}
+
+	// Validate tenant-style headers and expose them to handlers and the backend
+	if len(s.contextHeaders) > 0 {
+		add("context_headers", middleware.ContextHeaders(s.contextHeaders))
+	}
+
+	// Cut off clients that trickle their request body
+	if s.config.Server.BodyReadTimeout > 0 {
+		add("body_read_timeout", middleware.BodyReadTimeout(s.config.Server.BodyReadTimeout))
+	}
+
+	// Give up on slow requests with a 504, per route where configured
+	if srv := s.config.Server; srv.RequestTimeout > 0 || len(srv.RouteTimeouts) > 0 {
+		add("request_timeout", middleware.Timeout(srv.RequestTimeout, routeTimeouts(srv.RouteTimeouts)))
+	}
+
+	// Add CORS if enabled
+	if mw := s.config.Middleware; mw.EnableCORS {
+		add("cors", middleware.CORSWithPolicies(corsPolicy(mw.CORSPolicy), corsRoutes(mw.CORSRoutes)))
+	}
+
+	// Add HSTS if configured (only emitted under TLS)
+	if sec := s.config.Security; sec.HSTSMaxAge > 0 {
+		add("hsts", middleware.HSTS(sec.HSTSMaxAge, sec.HSTSIncludeSubDomains, sec.HSTSPreload))
+	}
+
+	// Add security headers
+	add("security", middleware.Security)
+
+	// Point HTTPS clients at the QUIC listener
+	if s.http3Server != nil {
+		add("alt_svc", s.advertiseHTTP3)
+	}
+
+	// Let Shutdown close event streams and WebSockets instead of waiting on them
+	if s.config.Server.StreamShutdownGrace > 0 {
+		add("stream_tracking", s.trackStreams)
+	}
+
+	// Serve repeat GETs from memory according to the per-content-type rules
+	if s.responseCache != nil {
+		add("response_cache", s.responseCache.middleware)
+	}
+
+	// Keep active event streams alive past the normal timeouts
+	if s.config.Server.SSEIdleTimeout > 0 {
+		add("sse_idle_timeout", s.sseIdleTimeout)
+	}
+
+	// Answer OPTIONS consistently for every route
+	add("options", s.handleOptions)
+
+	return chain
+}
+
+// requestLogger picks the request logging middleware: the configured access
+// log format, or structured logs to the access log file or the default logger
+func (s *Server) requestLogger() func(http.Handler) http.Handler {
+	var out io.Writer = log.Writer()
+	if s.accessLogFile != nil {
+		out = s.accessLogFile
+	}
+
+	switch {
+	case s.accessLogFormat != nil:
+		return middleware.AccessLog(s.accessLogFormat, out)
+	case s.accessLogFile != nil:
+		return middleware.RequestLogger(newLogger(s.config, out), logQueryFilter(s.config), s.logStatusFilter)
+	default:
+		return middleware.RequestLogger(s.logger, logQueryFilter(s.config), s.logStatusFilter)
+	}
+}
+
+// corsPolicy converts a configured CORS policy for the middleware
+func corsPolicy(p config.CORSPolicy) middleware.CORSPolicy {
+	return middleware.CORSPolicy{
+		AllowOrigins: p.AllowOrigins,
+		AllowMethods: p.AllowMethods,
+		AllowHeaders: p.AllowHeaders,
+	}
+}
+
+// corsRoutes converts the configured per-prefix CORS overrides
+func corsRoutes(routes []config.CORSRoute) []middleware.CORSRoute {
+	converted := make([]middleware.CORSRoute, 0, len(routes))
+	for _, route := range routes {
+		converted = append(converted, middleware.CORSRoute{
+			PathPrefix: route.PathPrefix,
+			Policy:     corsPolicy(route.CORSPolicy),
+		})
+	}
+	return converted
+}
+
+// routeTimeouts converts the configured per-prefix request timeouts
+func routeTimeouts(routes []config.RouteTimeout) []middleware.RouteTimeout {
+	converted := make([]middleware.RouteTimeout, 0, len(routes))
+	for _, route := range routes {
+		converted = append(converted, middleware.RouteTimeout{PathPrefix: route.PathPrefix, Timeout: route.Timeout})
+	}
+	return converted
+}
+
+// compileContextHeaders compiles the configured context headers, anchoring
+// each pattern so it must match the whole value
+func compileContextHeaders(headers []config.ContextHeader) ([]middleware.ContextHeader, error) {
+	compiled := make([]middleware.ContextHeader, 0, len(headers))
+	for _, header := range headers {
+		re, err := regexp.Compile(`^(?:` + header.Pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for context header %s: %w", header.Name, err)
+		}
+		compiled = append(compiled, middleware.ContextHeader{Name: header.Name, Pattern: re})
+	}
+	return compiled, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// grpcTimeoutUnits maps grpc-timeout unit suffixes to durations
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// clientTimeout extracts the time budget a client granted its request from
+// X-Request-Deadline (a duration such as "1.5s" or an RFC 3339 timestamp) or
+// grpc-timeout (e.g. "500m"). It reports false when neither header is usable.
+func clientTimeout(r *http.Request) (time.Duration, bool) {
+	if value := r.Header.Get("X-Request-Deadline"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d, true
+		}
+		if deadline, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return time.Until(deadline), true
+		}
+	}
+
+	if value := r.Header.Get("grpc-timeout"); len(value) >= 2 && len(value) <= 9 {
+		unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+		amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if ok && err == nil && amount > 0 {
+			return time.Duration(amount) * unit, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// expvarStats publishes the request counters and uptime in expvar format
+func (s *Server) expvarStats() expvar.Func {
+	return func() any {
+		snapshot := s.metrics.Snapshot()
+		return map[string]any{
+			"requests":       snapshot.Requests,
+			"bytes_sent":     snapshot.BytesSent,
+			"in_flight":      snapshot.InFlight,
+			"status":         snapshot.Status,
+			"uptime_seconds": int64(time.Since(s.startTime).Seconds()),
+		}
+	}
+}
+
+// handleExpvar responds to /debug/vars with the process-wide expvar variables
+// (cmdline, memstats, ...) plus this server's counters under "featherjet".
+// The counters are not published globally so several servers can coexist.
+func (s *Server) handleExpvar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+
+	stats, err := json.Marshal(s.expvarStats().Value())
+	if err != nil {
+		stats = []byte("null")
+	}
+	fmt.Fprintf(w, "%q: %s\n}\n", "featherjet", stats)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Handle registers a custom handler for pattern alongside the built-in routes.
+// The handler runs behind the configured middleware chain. Handlers must be
+// registered before Start. Like http.ServeMux, Handle panics when the pattern
+// conflicts with an existing route or falls under the reserved /api prefix.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	if isReservedPattern(pattern) {
+		panic(fmt.Sprintf("featherjet: pattern %q conflicts with reserved /api routes", pattern))
+	}
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a custom handler function for pattern; see Handle
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// isReservedPattern reports whether a mux pattern, optionally prefixed with a
+// host, targets the built-in /api namespace
+func isReservedPattern(pattern string) bool {
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern == "/api" || strings.HasPrefix(pattern, "/api/")
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// headerByteBudget is how much a client may send before its first request's
+// headers are complete; it matches net/http's own header size limit
+const headerByteBudget = http.DefaultMaxHeaderBytes
+
+// errHeaderBudget is returned when a client sends more header bytes than
+// headerByteBudget allows
+var errHeaderBudget = errors.New("request header byte budget exceeded")
+
+// headerDeadlineListener limits how long and how many bytes a new connection
+// may take to deliver its first request's headers. Unlike ReadHeaderTimeout,
+// net/http cannot reset this deadline while a client trickles bytes in.
+type headerDeadlineListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (ln headerDeadlineListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	hc := &headerDeadlineConn{Conn: conn, deadline: time.Now().Add(ln.timeout)}
+	hc.Conn.SetReadDeadline(hc.deadline)
+	return hc, nil
+}
+
+// headerDeadlineConn enforces the header deadline until the blank line ending
+// the first request's headers has been read, then gets out of the way
+type headerDeadlineConn struct {
+	net.Conn
+	deadline time.Time
+
+	mu        sync.Mutex
+	done      bool
+	read      int
+	tail      []byte
+	requested time.Time
+}
+
+func (c *headerDeadlineConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+	if done {
+		return c.Conn.Read(p)
+	}
+
+	if time.Now().After(c.deadline) {
+		c.Conn.Close()
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n, err := c.Conn.Read(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Look for the end of the headers, including across read boundaries.
+	// Like net/http, accept bare LF line endings: "\n\n" also matches
+	// "\r\n\n", and "\n\r\n" matches "\r\n\r\n".
+	window := append(c.tail, p[:n]...)
+	if bytes.Contains(window, []byte("\n\n")) || bytes.Contains(window, []byte("\n\r\n")) {
+		c.done = true
+		c.Conn.SetReadDeadline(c.requested)
+		return n, err
+	}
+	if len(window) > 3 {
+		window = window[len(window)-3:]
+	}
+	c.tail = append(c.tail[:0], window...)
+
+	c.read += n
+	if c.read > headerByteBudget {
+		c.Conn.Close()
+		return n, errHeaderBudget
+	}
+	return n, err
+}
+
+// SetReadDeadline lets net/http shorten, but never extend, the header
+// deadline while the headers are still being read
+func (c *headerDeadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requested = t
+	if !c.done && (t.IsZero() || t.After(c.deadline)) {
+		t = c.deadline
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetDeadline applies the same header clamp to the read side
+func (c *headerDeadlineConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
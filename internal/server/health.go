@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BeginDrain marks the server as not ready so load balancers stop routing new
+// traffic to it. In-flight and new requests are still served until Shutdown.
+func (s *Server) BeginDrain() {
+	if s.draining.CompareAndSwap(false, true) {
+		s.logger.Info("FeatherJet is draining: readiness now reports unavailable")
+	}
+}
+
+// Draining reports whether BeginDrain has been called
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// handleReadyz responds to /api/readyz with 200 when ready and 503 while
+// draining, with Server.ReadinessCheckDisk when a directory the server
+// writes to is not writable, and with Server.ReadinessCheckBackend when a
+// proxy backend is unreachable
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	response := map[string]interface{}{
+		"status":    "ready",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if s.Draining() {
+		status = http.StatusServiceUnavailable
+		response["status"] = "draining"
+	} else if s.config.Server.ReadinessCheckDisk {
+		if unwritable := unwritableDirs(s.writableDirs()); len(unwritable) > 0 {
+			status = http.StatusServiceUnavailable
+			response["status"] = "unavailable"
+			response["unwritable_dirs"] = unwritable
+		}
+	}
+	if status == http.StatusOK && s.config.Server.ReadinessCheckBackend && s.proxyTransport != nil {
+		if unreachable := s.unreachableBackends(r.Context()); len(unreachable) > 0 {
+			status = http.StatusServiceUnavailable
+			response["status"] = "unavailable"
+			response["unreachable_backends"] = unreachable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// writableDirs lists the directories the server writes to at runtime: the
+// access log directory and the autocert cache directory
+func (s *Server) writableDirs() []string {
+	var dirs []string
+	if file := s.config.Logging.AccessLogFile; file != "" && s.config.Logging.EnableRequestLogging {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	if dir := s.config.TLS.AutoCert.CacheDir; s.config.AutoCertEnabled() && dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// unwritableDirs returns the dirs in which a temporary file cannot be created
+func unwritableDirs(dirs []string) []string {
+	var unwritable []string
+	for _, dir := range dirs {
+		f, err := os.CreateTemp(dir, ".featherjet-readyz-*")
+		if err != nil {
+			unwritable = append(unwritable, dir)
+			continue
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return unwritable
+}
+
+// unreachableBackends probes every proxy backend in parallel with a HEAD
+// request and returns those that fail, answer 5xx, or take longer than
+// Proxy.HealthCheckTimeout
+func (s *Server) unreachableBackends(ctx context.Context) []string {
+	backends := []string{s.config.Proxy.Target}
+	if targets := s.config.Proxy.Targets; len(targets) > 0 {
+		backends = backends[:0]
+		for _, target := range targets {
+			backends = append(backends, target.URL)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Proxy.HealthCheckTimeout)
+	defer cancel()
+
+	reachable := make([]bool, len(backends))
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, backend string) {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, backend, nil)
+			if err != nil {
+				return
+			}
+			resp, err := s.proxyTransport.RoundTrip(req)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			reachable[i] = resp.StatusCode < http.StatusInternalServerError
+		}(i, backend)
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for i, backend := range backends {
+		if !reachable[i] {
+			unreachable = append(unreachable, backend)
+		}
+	}
+	return unreachable
+}
+
+// handleDrain responds to POST /api/drain by starting a drain. It is only
+// accepted from loopback clients, e.g. a Kubernetes preStop hook, so requests
+// relayed by a local trusted proxy are judged by their forwarded address.
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isLoopback(s.clientAddr(r)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	s.BeginDrain()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "draining",
+	})
+}
+
+// isLoopback reports whether a RemoteAddr belongs to the local machine
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseCIDRs parses CIDR ranges, accepting bare IPs as single-host ranges
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// addrInNets reports whether the host of remoteAddr falls inside any of nets
+func addrInNets(remoteAddr string, nets []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// setupHTTP3 prepares the QUIC server answering HTTP/3 on the main address.
+// It shares the handler and TLS settings of the HTTPS listener once Start
+// binds it.
+func (s *Server) setupHTTP3() {
+	s.http3Server = &http3.Server{
+		Addr:           s.httpServer.Addr,
+		MaxHeaderBytes: s.httpServer.MaxHeaderBytes,
+	}
+}
+
+// startHTTP3 binds (or inherits) the UDP socket and serves HTTP/3 on it in
+// the background. Failures are logged and leave the server on TCP only, in
+// which case Alt-Svc is never advertised.
+func (s *Server) startHTTP3() {
+	tlsConfig, err := s.http3TLSConfig()
+	if err != nil {
+		s.logger.Error("HTTP/3 server failed", "error", err)
+		return
+	}
+
+	conn, err := listenPacketOrInherit(http3FDEnv, s.http3Server.Addr)
+	if err != nil {
+		s.logger.Error("HTTP/3 server failed", "error", err)
+		return
+	}
+	s.http3Conn = conn
+
+	s.http3Server.TLSConfig = tlsConfig
+	s.http3Server.Handler = s.httpServer.Handler
+
+	s.http3Done = make(chan struct{})
+	go func() {
+		defer close(s.http3Done)
+		if err := s.http3Server.Serve(conn); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("HTTP/3 server failed", "error", err)
+		}
+	}()
+}
+
+// http3TLSConfig derives the QUIC TLS config from the HTTPS listener's,
+// loading the configured certificate the way ServeTLS does. Autocert configs
+// already carry a certificate callback.
+func (s *Server) http3TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if s.httpServer.TLSConfig != nil {
+		tlsConfig = s.httpServer.TLSConfig.Clone()
+	}
+
+	if certFile, keyFile := s.config.TLS.CertFile, s.config.TLS.KeyFile; certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// advertiseHTTP3 adds Alt-Svc to HTTPS responses while the QUIC listener is
+// up, so clients only switch to HTTP/3 when it can actually be reached
+func (s *Server) advertiseHTTP3(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			// Fails harmlessly with ErrNoAltSvcPort when nothing is listening
+			s.http3Server.SetQuicHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stopHTTP3 closes the QUIC listener and waits until Alt-Svc is no longer
+// advertised. quic-go has no graceful close yet, so HTTP/3 requests still
+// running are aborted.
+func (s *Server) stopHTTP3() {
+	s.http3Server.Close()
+	<-s.http3Done
+	s.http3Conn.Close()
+}
+
+// listenPacketOrInherit is listenOrInherit for the UDP socket
+func listenPacketOrInherit(env, addr string) (net.PacketConn, error) {
+	fdStr := os.Getenv(env)
+	if fdStr == "" {
+		return net.ListenPacket("udp", addr)
+	}
+
+	// Only the direct child should inherit the socket
+	os.Unsetenv(env)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", env, fdStr, err)
+	}
+
+	file := os.NewFile(uintptr(fd), "featherjet-http3")
+	if file == nil {
+		return nil, fmt.Errorf("inherited file descriptor %d is not valid", fd)
+	}
+	defer file.Close()
+
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited UDP socket: %w", err)
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptedLanguages lists the language ranges in an Accept-Language header,
+// most preferred first. Each range is followed by its primary subtag, so
+// "fr-CA" also matches pages written for "fr". Wildcards and q=0 are skipped.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var ranges []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		ranges = append(ranges, weighted{tag, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	var tags []string
+	seen := map[string]bool{}
+	for _, r := range ranges {
+		primary, _, _ := strings.Cut(r.tag, "-")
+		for _, tag := range []string{r.tag, primary} {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// negotiateLanguage points r at the language variant of the HTML page it
+// requests that best matches Accept-Language, following the page.<lang>.html
+// convention (index.fr.html, about.fr.html). The untagged page is preferred
+// over Static.DefaultLanguage when no accepted language has a variant.
+func (s *Server) negotiateLanguage(w http.ResponseWriter, r *http.Request, root string) *http.Request {
+	page := r.URL.Path
+	if strings.HasSuffix(page, "/") {
+		page += "index.html"
+	}
+	base, ok := strings.CutSuffix(page, ".html")
+	if !ok {
+		return r
+	}
+	w.Header().Add("Vary", "Accept-Language")
+
+	exists := func(urlPath string) bool {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(urlPath)))
+		return err == nil && info.Mode().IsRegular()
+	}
+	variant := func(lang string) (*http.Request, bool) {
+		candidate := base + "." + lang + ".html"
+		if !exists(candidate) {
+			return nil, false
+		}
+		w.Header().Set("Content-Language", lang)
+		return withURLPath(r, candidate), true
+	}
+
+	for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		if negotiated, ok := variant(lang); ok {
+			return negotiated
+		}
+	}
+	if exists(page) {
+		return r
+	}
+	if lang := strings.ToLower(s.config.Static.DefaultLanguage); lang != "" {
+		if negotiated, ok := variant(lang); ok {
+			return negotiated
+		}
+	}
+	return r
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// These tell a re-executed child which inherited file descriptors hold the
+// main, admin and ACME challenge listening sockets and the QUIC socket
+const (
+	listenerFDEnv          = "FEATHERJET_LISTENER_FD"
+	adminListenerFDEnv     = "FEATHERJET_ADMIN_LISTENER_FD"
+	challengeListenerFDEnv = "FEATHERJET_CHALLENGE_LISTENER_FD"
+	http3FDEnv             = "FEATHERJET_HTTP3_FD"
+)
+
+// listenConfig returns the socket options for the main listener
+func (s *Server) listenConfig() net.ListenConfig {
+	var lc net.ListenConfig
+	if s.config.Server.ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc
+}
+
+// listenOrInherit returns the listener handed down by a parent process during
+// a graceful restart in the descriptor named by env, or opens a fresh one on
+// addr using lc
+func listenOrInherit(env, addr string, lc net.ListenConfig) (net.Listener, error) {
+	fdStr := os.Getenv(env)
+	if fdStr == "" {
+		return lc.Listen(context.Background(), "tcp", addr)
+	}
+
+	// Only the direct child should inherit the socket
+	os.Unsetenv(env)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", env, fdStr, err)
+	}
+
+	file := os.NewFile(uintptr(fd), "featherjet-listener")
+	if file == nil {
+		return nil, fmt.Errorf("inherited file descriptor %d is not valid", fd)
+	}
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited listener: %w", err)
+	}
+
+	return ln, nil
+}
+
+// keepAliveConn is implemented by connections supporting TCP keep-alive
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// keepAliveListener applies the configured TCP keep-alive period to every
+// accepted connection, including those on inherited listeners. A negative
+// period turns keep-alive off.
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (ln keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if kc, ok := conn.(keepAliveConn); ok {
+		if ln.period < 0 {
+			kc.SetKeepAlive(false)
+		} else {
+			kc.SetKeepAlive(true)
+			kc.SetKeepAlivePeriod(ln.period)
+		}
+	}
+	return conn, nil
+}
+
+// handoffFile is a duplicated listening socket and the environment variable
+// that names its descriptor in the child
+type handoffFile struct {
+	env  string
+	file *os.File
+}
+
+// handoffFiles duplicates every listener a restarted process has to inherit,
+// since the admin, challenge and QUIC addresses stay bound here until
+// Shutdown completes. The caller closes the files.
+func (s *Server) handoffFiles() ([]handoffFile, error) {
+	if s.listener == nil {
+		return nil, fmt.Errorf("server is not listening")
+	}
+
+	listeners := []struct {
+		env string
+		ln  net.Listener
+	}{
+		{listenerFDEnv, s.listener},
+		{adminListenerFDEnv, s.adminListener},
+		{challengeListenerFDEnv, s.challengeListener},
+	}
+
+	var files []handoffFile
+	for _, l := range listeners {
+		if l.ln == nil {
+			continue
+		}
+		tcpListener, ok := l.ln.(*net.TCPListener)
+		if !ok {
+			closeHandoffFiles(files)
+			return nil, fmt.Errorf("listener of type %T cannot be handed off", l.ln)
+		}
+		file, err := tcpListener.File()
+		if err != nil {
+			closeHandoffFiles(files)
+			return nil, fmt.Errorf("failed to get listener file: %w", err)
+		}
+		files = append(files, handoffFile{env: l.env, file: file})
+	}
+
+	if udpConn, ok := s.http3Conn.(*net.UDPConn); ok {
+		file, err := udpConn.File()
+		if err != nil {
+			closeHandoffFiles(files)
+			return nil, fmt.Errorf("failed to get UDP socket file: %w", err)
+		}
+		files = append(files, handoffFile{env: http3FDEnv, file: file})
+	}
+	return files, nil
+}
+
+func closeHandoffFiles(files []handoffFile) {
+	for _, f := range files {
+		f.file.Close()
+	}
+}
+
+// Restart re-executes the current binary, passing it the listening sockets so
+// the new process can accept connections while this one drains. The caller is
+// expected to call Shutdown once Restart returns successfully.
+func (s *Server) Restart() (*os.Process, error) {
+	files, err := s.handoffFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer closeHandoffFiles(files)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for i, f := range files {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f.file)
+		// ExtraFiles[i] becomes file descriptor 3+i in the child
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", f.env, 3+i))
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	return cmd.Process, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// listingSorters orders directory entries for each Static.ListingSort value
+var listingSorters = map[string]func(a, b fs.FileInfo) bool{
+	"name":         func(a, b fs.FileInfo) bool { return a.Name() < b.Name() },
+	"name_desc":    func(a, b fs.FileInfo) bool { return a.Name() > b.Name() },
+	"size":         func(a, b fs.FileInfo) bool { return a.Size() < b.Size() },
+	"size_desc":    func(a, b fs.FileInfo) bool { return a.Size() > b.Size() },
+	"modtime":      func(a, b fs.FileInfo) bool { return a.ModTime().Before(b.ModTime()) },
+	"modtime_desc": func(a, b fs.FileInfo) bool { return a.ModTime().After(b.ModTime()) },
+}
+
+// serveDirectoryListing renders the entries of dir sorted by
+// Static.ListingSort. Blocked dotfiles are left out of the listing.
+func (s *Server) serveDirectoryListing(w http.ResponseWriter, r *http.Request, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if s.config.Static.BlockDotfiles && isBlockedDotfile(entry.Name(), s.config.Static.DotfileAllowlist) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+
+	less := listingSorters[s.config.Static.ListingSort]
+	sort.SliceStable(infos, func(i, j int) bool {
+		return less(infos[i], infos[j])
+	})
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	b.WriteString("</pre>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// rotatingFile is an append-only log file that is rotated once it grows past
+// maxSize bytes. Rotated files keep a timestamp suffix and are optionally
+// gzipped in the background.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	compress bool
+	file     *os.File
+	size     int64
+
+	// onCompressError, when set, reports a rotated file that failed to compress
+	onCompressError func(error)
+	compressing     sync.WaitGroup
+}
+
+// openRotatingFile opens (or creates) the log file at path for appending
+func openRotatingFile(path string, maxSize int64, compress bool) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, compress: compress}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would push it past maxSize
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one. The new file is
+// opened before anything else can fail, and compression runs in the
+// background so writers never wait on it.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := f.path + "." + time.Now().Format("20060102-150405.000000000")
+	renameErr := os.Rename(f.path, rotated)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate log file: %w", renameErr)
+	}
+
+	if f.compress {
+		f.compressing.Add(1)
+		go func() {
+			defer f.compressing.Done()
+			if err := gzipFile(rotated); err != nil && f.onCompressError != nil {
+				f.onCompressError(fmt.Errorf("failed to compress rotated log file: %w", err))
+			}
+		}()
+	}
+	return nil
+}
+
+// Close closes the current log file once pending compressions finish
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	err := f.file.Close()
+	f.mu.Unlock()
+
+	f.compressing.Wait()
+	return err
+}
+
+// gzipFile replaces path with a gzipped copy named path.gz
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/config"
+	"github.com/featherjet/featherjet/internal/middleware"
+)
+
+// logLevels maps configured level names to slog levels
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// slogTimeLayout matches the timestamps slog writes by default
+const slogTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// logTimeFormatter renders log timestamps using Logging.TimeFormat and
+// Logging.TimeZone, falling back to defaultLayout. It returns nil when
+// neither is configured.
+func logTimeFormatter(cfg *config.Config, defaultLayout string) func(time.Time) string {
+	if cfg.Logging.TimeFormat == "" && cfg.Logging.TimeZone == "" {
+		return nil
+	}
+
+	// TimeZone is validated by config.Validate
+	loc, _ := cfg.LogLocation()
+
+	layout := defaultLayout
+	switch cfg.Logging.TimeFormat {
+	case "":
+	case "rfc3339":
+		layout = time.RFC3339
+	case "unix":
+		return func(t time.Time) string {
+			return strconv.FormatInt(t.Unix(), 10)
+		}
+	default:
+		layout = cfg.Logging.TimeFormat
+	}
+
+	return func(t time.Time) string {
+		return t.In(loc).Format(layout)
+	}
+}
+
+// logQueryFilter applies Logging.LogQueryString and
+// Logging.RedactQueryParams to logged query strings
+func logQueryFilter(cfg *config.Config) middleware.QueryFilter {
+	return middleware.QueryFilter{
+		Omit:   !cfg.Logging.LogQueryString,
+		Redact: cfg.Logging.RedactQueryParams,
+	}
+}
+
+// newLogger builds the default logger from the logging config
+func newLogger(cfg *config.Config, out io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: logLevels[cfg.Logging.Level]}
+
+	if formatTime := logTimeFormatter(cfg, slogTimeLayout); formatTime != nil {
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.String(slog.TimeKey, formatTime(a.Value.Time()))
+			}
+			return a
+		}
+	}
+
+	if cfg.Logging.Format == "json" {
+		return slog.New(slog.NewJSONHandler(out, opts))
+	}
+	return slog.New(slog.NewTextHandler(out, opts))
+}
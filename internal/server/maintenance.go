@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+)
+
+// maintenancePage is served to visitors while maintenance mode is active
+const maintenancePage = `<!DOCTYPE html>
+<html>
+<head><title>503 Service Unavailable</title></head>
+<body>
+<h1>Down for maintenance</h1>
+<p>We are performing scheduled maintenance and will be back shortly.</p>
+</body>
+</html>
+`
+
+// SetMaintenance turns maintenance mode on or off at runtime
+func (s *Server) SetMaintenance(enabled bool) {
+	if s.maintenance.Swap(enabled) != enabled {
+		s.logger.Info("maintenance mode changed", "enabled", enabled)
+	}
+}
+
+// InMaintenance reports whether maintenance mode is active
+func (s *Server) InMaintenance() bool {
+	return s.maintenance.Load()
+}
+
+// maintenanceMiddleware answers 503 while maintenance mode is active, except
+// for allowlisted clients and the status and readiness probes
+func (s *Server) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.InMaintenance() || r.URL.Path == "/api/status" || r.URL.Path == "/api/readyz" ||
+			addrInNets(r.RemoteAddr, s.maintenanceAllow) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Retry-After", "120")
+		if isAPIPath(r.URL.Path) {
+			http.Error(w, "Service unavailable: down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(maintenancePage))
+	})
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultNotFoundPage is served when no custom 404 page is configured
+const defaultNotFoundPage = `<!DOCTYPE html>
+<html>
+<head><title>404 Not Found</title></head>
+<body>
+<h1>404 Not Found</h1>
+<p>The requested resource could not be found on this server.</p>
+</body>
+</html>
+`
+
+// defaultBadGatewayPage is served when the backend cannot be reached and no
+// custom proxy error page is configured
+const defaultBadGatewayPage = `<!DOCTYPE html>
+<html>
+<head><title>502 Bad Gateway</title></head>
+<body>
+<h1>502 Bad Gateway</h1>
+<p>The upstream service is unavailable. Please try again shortly.</p>
+</body>
+</html>
+`
+
+// isAPIPath reports whether the path falls under the reserved /api prefix
+func isAPIPath(urlPath string) bool {
+	return urlPath == "/api" || strings.HasPrefix(urlPath, "/api/")
+}
+
+// acceptQuality returns the highest q-value the Accept header gives any of the
+// media types, or -1 if none of them is listed
+func acceptQuality(r *http.Request, mediaTypes ...string) float64 {
+	best := -1.0
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		for _, candidate := range mediaTypes {
+			if mediaType == candidate && q > best {
+				best = q
+			}
+		}
+	}
+
+	return best
+}
+
+// prefersJSON reports whether the Accept header ranks application/json at
+// least as high as text/html
+func prefersJSON(r *http.Request) bool {
+	jsonQ := acceptQuality(r, "application/json")
+	return jsonQ > 0 && jsonQ >= acceptQuality(r, "text/html")
+}
+
+// prefersYAML reports whether the Accept header explicitly ranks a YAML media
+// type above application/json
+func prefersYAML(r *http.Request) bool {
+	yamlQ := acceptQuality(r, "application/yaml", "application/x-yaml", "text/yaml")
+	return yamlQ > 0 && yamlQ > acceptQuality(r, "application/json")
+}
+
+// handleAPINotFound answers unknown /api paths with a JSON or HTML 404
+// depending on what the client accepts
+func (s *Server) handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	if prefersJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "not found",
+			"status": http.StatusNotFound,
+			"path":   r.URL.Path,
+		})
+		return
+	}
+
+	s.serveNotFoundPage(w, r)
+}
+
+// serveNotFoundPage writes the configured HTML error page with a 404 status
+func (s *Server) serveNotFoundPage(w http.ResponseWriter, r *http.Request) {
+	page := []byte(defaultNotFoundPage)
+	if s.config.Static.NotFoundPage != "" {
+		custom, err := os.ReadFile(filepath.Join(s.config.Static.Directory, s.config.Static.NotFoundPage))
+		if err == nil {
+			page = custom
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(page)
+}
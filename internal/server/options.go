@@ -0,0 +1,16 @@
+package server
+
+import (
+	"log/slog"
+)
+
+// Option customizes a Server created by New
+type Option func(*Server)
+
+// WithLogger makes the server, its handlers and middleware log through l
+// instead of a logger built from the configured level and format
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// perIPListener refuses connections from a client IP that already holds max
+// open connections. Refused connections are closed right after accept,
+// before any bytes are read.
+type perIPListener struct {
+	net.Listener
+	max    int
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newPerIPListener(ln net.Listener, max int, logger *slog.Logger) *perIPListener {
+	return &perIPListener{Listener: ln, max: max, logger: logger, counts: make(map[string]int)}
+}
+
+func (ln *perIPListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+
+		if !ln.acquire(ip) {
+			ln.logger.Debug("refused connection over per-IP limit", "client_ip", ip, "limit", ln.max)
+			conn.Close()
+			continue
+		}
+		return &perIPConn{Conn: conn, release: func() { ln.release(ip) }}, nil
+	}
+}
+
+// acquire counts a new connection from ip unless it is already at the limit
+func (ln *perIPListener) acquire(ip string) bool {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if ln.counts[ip] >= ln.max {
+		return false
+	}
+	ln.counts[ip]++
+	return true
+}
+
+func (ln *perIPListener) release(ip string) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	if ln.counts[ip]--; ln.counts[ip] <= 0 {
+		delete(ln.counts, ip)
+	}
+}
+
+// perIPConn gives its slot back the first time it is closed, including
+// after being hijacked for a WebSocket
+type perIPConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *perIPConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/featherjet/featherjet/internal/middleware"
+)
+
+// precompressStatic writes a .gz copy next to every compressible static file
+// that lacks an up-to-date one, using a pool of workers
+func (s *Server) precompressStatic() error {
+	workers := s.config.Static.PrecompressWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	files := make(chan string)
+	var wg sync.WaitGroup
+	var compressed, failed int64
+	var mu sync.Mutex
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				err := gzipStaticFile(file)
+
+				mu.Lock()
+				if err != nil {
+					failed++
+					s.logger.Warn("failed to precompress static file", "file", file, "error", err)
+				} else {
+					compressed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err := filepath.WalkDir(s.config.Static.Directory, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(file, ".gz") {
+			return err
+		}
+		if !middleware.Compressible(mime.TypeByExtension(filepath.Ext(file))) || gzipUpToDate(file) {
+			return nil
+		}
+		files <- file
+		return nil
+	})
+	close(files)
+	wg.Wait()
+
+	s.logger.Info("precompressed static files", "compressed", compressed, "failed", failed, "workers", workers)
+	return err
+}
+
+// gzipUpToDate reports whether file already has a .gz copy at least as new
+func gzipUpToDate(file string) bool {
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	gzInfo, err := os.Stat(file + ".gz")
+	return err == nil && !gzInfo.ModTime().Before(info.ModTime())
+}
+
+// gzipStaticFile writes file.gz at best compression, keeping file in place
+func gzipStaticFile(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".precompress-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz, _ := gzip.NewWriterLevel(tmp, gzip.BestCompression)
+	if _, err := io.Copy(gz, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file+".gz")
+}
+
+// servePrecompressed serves the .gz variant of a static file to clients that
+// accept gzip. It reports false when no variant applies.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, root, urlPath string) bool {
+	if !middleware.AcceptsGzip(r) || strings.HasSuffix(urlPath, "/") {
+		return false
+	}
+
+	resolved, decision := resolveStaticPath(root, urlPath)
+	if decision != "served file" || !gzipUpToDate(resolved) {
+		return false
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(urlPath))
+	if contentType == "" {
+		return false
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	http.ServeFile(w, r, resolved+".gz")
+	return true
+}
@@ -0,0 +1,526 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/middleware"
+)
+
+// newTasksProxy builds the reverse proxy to the VelocityTasks backend
+func (s *Server) newTasksProxy() (*httputil.ReverseProxy, error) {
+	// Target is validated by config.Validate
+	target, _ := url.Parse(s.config.Proxy.Target)
+
+	transport, err := s.newProxyTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	s.proxyTransport = transport
+
+	if maxAge := s.config.Proxy.MaxConnAge; maxAge > 0 {
+		s.stopConnRefresh = refreshIdleConns(transport, maxAge)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	if len(s.config.Proxy.Targets) > 0 {
+		s.balancer = newWeightedBalancer(s.config.Proxy.Targets)
+		proxy.Director = s.balancer.director
+	}
+	if s.config.Proxy.AllowCanaryHeader {
+		proxy.Director = s.canaryDirector(proxy.Director)
+	}
+	if len(s.contextHeaders) > 0 {
+		proxy.Director = forwardContextHeaders(proxy.Director)
+	}
+	proxy.Transport = transport
+	if max := s.config.Proxy.MaxConcurrentPerBackend; max > 0 {
+		proxy.Transport = newBackendLimiter(transport, max)
+	}
+	proxy.ModifyResponse = s.modifyProxyResponse
+	proxy.ErrorHandler = s.handleProxyError
+	proxy.ErrorLog = slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn)
+	// text/event-stream responses are always flushed immediately; this only
+	// affects other streamed responses
+	proxy.FlushInterval = s.config.Proxy.FlushInterval
+
+	if s.config.Proxy.GRPCWeb {
+		// gRPC-Web frames must reach the client as soon as they arrive
+		grpcWebProxy := *proxy
+		grpcWebProxy.FlushInterval = -1
+		s.grpcWebProxy = &grpcWebProxy
+	}
+
+	return proxy, nil
+}
+
+// forwardContextHeaders wraps a director so the backend receives exactly the
+// context header values validated by middleware.ContextHeaders
+func forwardContextHeaders(next func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		next(req)
+		for name, value := range middleware.ContextHeaderValues(req.Context()) {
+			req.Header.Set(name, value)
+		}
+	}
+}
+
+// requestHeaderBytes is the wire size of header as "Name: value\r\n" lines.
+// Names are already canonicalized by net/http, and hop-by-hop headers such as
+// Connection and Keep-Alive are removed later by ReverseProxy, so the count
+// slightly overestimates what the backend receives.
+func requestHeaderBytes(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(": ") + len(value) + len("\r\n")
+		}
+	}
+	return size
+}
+
+// isGRPCWeb reports whether contentType is one of the gRPC-Web media types
+// (application/grpc-web, application/grpc-web+proto, application/grpc-web-text)
+func isGRPCWeb(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(mediaType)), "application/grpc-web")
+}
+
+// newProxyTransport builds the upstream transport, including the TLS settings
+// needed to reach a backend served with an internal CA or requiring mTLS
+func (s *Server) newProxyTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.config.Proxy.Expect100Continue {
+		// Uploads wait this long for the backend's 100 Continue before the
+		// body is sent anyway
+		transport.ExpectContinueTimeout = time.Second
+	}
+	tlsCfg := s.config.Proxy.TLS
+
+	if tlsCfg.CACertFile == "" && tlsCfg.CertFile == "" && !tlsCfg.InsecureSkipVerify {
+		return transport, nil
+	}
+
+	clientConfig := &tls.Config{
+		InsecureSkipVerify: tlsCfg.InsecureSkipVerify,
+	}
+
+	if tlsCfg.CACertFile != "" {
+		pem, err := os.ReadFile(tlsCfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proxy CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("proxy CA file %s contains no valid certificates", tlsCfg.CACertFile)
+		}
+		clientConfig.RootCAs = pool
+	}
+
+	if tlsCfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load proxy client certificate: %w", err)
+		}
+		clientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = clientConfig
+	return transport, nil
+}
+
+// refreshIdleConns closes the transport's idle connections every maxAge so
+// that new requests dial again and pick up backend DNS changes. It returns a
+// function stopping the refresh.
+func refreshIdleConns(transport *http.Transport, maxAge time.Duration) func() {
+	ticker := time.NewTicker(maxAge)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				transport.CloseIdleConnections()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// originalPathKey stores the client's request path for the static fallback
+type originalPathKey struct{}
+
+// staticFallbackError asks handleProxyError to serve a static file in place
+// of an upstream 404
+type staticFallbackError struct {
+	file string
+}
+
+func (e *staticFallbackError) Error() string {
+	return "upstream returned 404, serving static file " + e.file
+}
+
+// staticFallbackFile returns the static file matching urlPath, if any
+func (s *Server) staticFallbackFile(urlPath string) (string, bool) {
+	if s.config.Static.BlockDotfiles && isBlockedDotfile(urlPath, s.config.Static.DotfileAllowlist) {
+		return "", false
+	}
+
+	root, err := filepath.Abs(s.config.Static.Directory)
+	if err != nil {
+		return "", false
+	}
+
+	resolved, decision := resolveStaticPath(root, urlPath)
+	return resolved, decision == "served file"
+}
+
+// modifyProxyResponse filters upstream headers before they reach the client
+func (s *Server) modifyProxyResponse(resp *http.Response) error {
+	if s.config.Proxy.FallbackToStatic && resp.StatusCode == http.StatusNotFound {
+		if urlPath, ok := resp.Request.Context().Value(originalPathKey{}).(string); ok {
+			if file, ok := s.staticFallbackFile(urlPath); ok {
+				return &staticFallbackError{file: file}
+			}
+		}
+	}
+
+	for _, name := range s.config.Proxy.StripResponseHeaders {
+		resp.Header.Del(name)
+	}
+
+	for name, value := range s.config.Proxy.SetResponseHeaders {
+		resp.Header.Set(name, value)
+	}
+
+	for name := range s.config.Proxy.ResponseHeaders {
+		resp.Header.Del(name)
+	}
+
+	// Strict clients reject JSON without an explicit charset
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		resp.Header.Set("Content-Type", withCharset(contentType, ""))
+	}
+
+	if max := s.config.Proxy.MaxResponseHeaders; max > 0 && len(resp.Header) > max {
+		dropped := capHeaders(resp.Header, max)
+		s.logger.Warn("dropped excess upstream response headers",
+			"path", resp.Request.URL.Path, "limit", max, "dropped", dropped)
+	}
+
+	// Upgraded connections are relayed as a raw stream: wrapping the body in
+	// anything that is not an io.ReadWriteCloser would make ReverseProxy
+	// refuse the upgrade
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		s.recordUpstreamTiming(resp.Request, resp)
+		backend, ok := resp.Body.(io.ReadWriteCloser)
+		if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+			return nil
+		}
+		// Tracked streams get a close frame on shutdown
+		st, tracked := resp.Request.Context().Value(trackedStreamKey{}).(*trackedStream)
+		if interval := s.config.Proxy.WebSocketPingInterval; interval > 0 || tracked {
+			conn := newWSPingConn(backend, interval)
+			if tracked {
+				st.setGoAway(conn.goAway)
+			}
+			resp.Body = conn
+		}
+		return nil
+	}
+
+	// gRPC-Web clients need grpc-status even when trailers are otherwise dropped
+	grpcWeb := s.config.Proxy.GRPCWeb && isGRPCWeb(resp.Header.Get("Content-Type"))
+	if !s.config.Proxy.ForwardTrailers && !grpcWeb {
+		resp.Header.Del("Trailer")
+		resp.Trailer = nil
+		resp.Body = &trailerStrippingBody{ReadCloser: resp.Body, resp: resp}
+	}
+
+	s.recordUpstreamTiming(resp.Request, resp)
+
+	if s.config.Proxy.BufferResponses && !grpcWeb {
+		return bufferProxyResponse(resp, s.config.Proxy.BufferMaxBytes)
+	}
+
+	return nil
+}
+
+// bufferProxyResponse reads a response of unknown length into memory so it is
+// sent with a Content-Length instead of chunked. Streams, responses with
+// trailers and bodies larger than max are left to stream as usual.
+func bufferProxyResponse(resp *http.Response, max int) error {
+	if resp.ContentLength >= 0 || len(resp.Trailer) > 0 || resp.Request.Method == http.MethodHead {
+		return nil
+	}
+	if mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); strings.TrimSpace(mediaType) == "text/event-stream" {
+		return nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+	if err != nil {
+		return err
+	}
+
+	if len(buf) > max {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+		return nil
+	}
+
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(buf))
+	resp.ContentLength = int64(len(buf))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(buf)))
+	return nil
+}
+
+// essentialHeaders are never dropped by capHeaders since the response cannot
+// be framed or interpreted without them
+var essentialHeaders = map[string]bool{
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Location":          true,
+}
+
+// capHeaders trims h to at most max header names, keeping essential headers
+// and then the rest in alphabetical order. It returns the dropped names.
+func capHeaders(h http.Header, max int) []string {
+	names := make([]string, 0, len(h))
+	kept := 0
+	for name := range h {
+		if essentialHeaders[name] {
+			kept++
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var dropped []string
+	for _, name := range names {
+		if kept < max {
+			kept++
+			continue
+		}
+		h.Del(name)
+		dropped = append(dropped, name)
+	}
+	return dropped
+}
+
+// trailerStrippingBody drops the trailers the transport merges into resp once
+// the upstream body has been fully read
+type trailerStrippingBody struct {
+	io.ReadCloser
+	resp *http.Response
+}
+
+func (b *trailerStrippingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.resp.Trailer = nil
+	}
+	return n, err
+}
+
+// handleProxyError reports upstream failures to the client
+func (s *Server) handleProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	var fallback *staticFallbackError
+	if errors.As(err, &fallback) {
+		http.ServeFile(w, r, fallback.file)
+		return
+	}
+
+	s.recordUpstreamTiming(r, nil)
+
+	if middleware.BodyReadTimedOut(r) {
+		http.Error(w, "Request body read timeout", http.StatusRequestTimeout)
+		return
+	}
+
+	if errors.Is(err, errBackendSaturated) {
+		s.logger.Warn("proxy backend saturated", "method", r.Method, "path", r.URL.Path, "backend", r.URL.Host)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Backend overloaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	if middleware.BodyTooLarge(r) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Upstream request deadline exceeded", http.StatusGatewayTimeout)
+		return
+	}
+
+	s.logger.Error("proxy error", "method", r.Method, "path", r.URL.Path, "error", err)
+	s.serveBadGateway(w, r)
+}
+
+// serveBadGateway writes a complete 502 response in the format the client
+// prefers. It is only used before any upstream bytes reached the client.
+func (s *Server) serveBadGateway(w http.ResponseWriter, r *http.Request) {
+	if prefersJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "bad gateway",
+			"status": http.StatusBadGateway,
+		})
+		return
+	}
+
+	page := []byte(defaultBadGatewayPage)
+	if s.config.Proxy.ErrorPage != "" {
+		custom, err := os.ReadFile(filepath.Join(s.config.Static.Directory, s.config.Proxy.ErrorPage))
+		if err == nil {
+			page = custom
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write(page)
+}
+
+// isHTTPS reports whether the client reached us over HTTPS, either directly
+// or through a trusted proxy that set X-Forwarded-Proto
+func (s *Server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return addrInNets(r.RemoteAddr, s.trustedProxies) &&
+		strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// clientAddr returns the address of the client behind any trusted proxies.
+// X-Forwarded-For is only consulted when the connection comes from a trusted
+// proxy, and is read from the right so hops the client added are ignored.
+func (s *Server) clientAddr(r *http.Request) string {
+	addr := r.RemoteAddr
+	if !addrInNets(addr, s.trustedProxies) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr = hop
+		if !addrInNets(hop, s.trustedProxies) {
+			break
+		}
+	}
+	return addr
+}
+
+// handleTasksProxy forwards /api/tasks requests to VelocityTasks
+func (s *Server) handleTasksProxy(w http.ResponseWriter, r *http.Request) {
+	if s.proxy == nil {
+		http.Error(w, "Proxy target not configured", http.StatusBadGateway)
+		return
+	}
+
+	// Keep credentials off cleartext connections
+	if s.config.Proxy.RequireHTTPS && !s.isHTTPS(r) {
+		http.Error(w, "HTTPS required", http.StatusForbidden)
+		return
+	}
+
+	// Backends often accept smaller headers than we do; answer for them
+	// rather than forwarding a request they would reject or truncate
+	if max := s.config.Proxy.MaxRequestHeaderBytes; max > 0 && requestHeaderBytes(r.Header) > max {
+		http.Error(w, "Request header fields too large", http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	// Honor the client's own deadline, clamped to the configured maximum
+	if timeout, ok := clientTimeout(r); ok {
+		if max := s.config.Proxy.MaxRequestTimeout; max > 0 && timeout > max {
+			timeout = max
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// Without negotiation the backend gets the body straight away; the client
+	// still receives its 100 Continue from us once the body is read
+	if !s.config.Proxy.Expect100Continue {
+		r.Header.Del("Expect")
+	}
+
+	if s.config.Proxy.AllowCanaryHeader {
+		var ok bool
+		if r, ok = s.routeCanary(r); !ok {
+			http.Error(w, "Canary backend not allowed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Applied to every proxy response, including error pages; upstream
+	// values for the same headers are dropped in modifyProxyResponse
+	for name, value := range s.config.Proxy.ResponseHeaders {
+		w.Header().Set(name, value)
+	}
+
+	if s.config.Proxy.FallbackToStatic && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		r = r.WithContext(context.WithValue(r.Context(), originalPathKey{}, r.URL.Path))
+	}
+
+	// Once the upstream response has started, ReverseProxy aborts the
+	// connection on a copy error so the client sees a truncated response
+	// rather than a corrupt one
+	defer func() {
+		if err := recover(); err != nil {
+			if err == http.ErrAbortHandler {
+				s.logger.Warn("proxy response aborted mid-stream", "method", r.Method, "path", r.URL.Path)
+			}
+			panic(err)
+		}
+	}()
+
+	if s.config.Server.EmitServerTiming || s.config.Logging.LogUpstreamTiming {
+		r = markUpstreamStart(r)
+	}
+
+	if s.grpcWebProxy != nil && isGRPCWeb(r.Header.Get("Content-Type")) {
+		s.grpcWebProxy.ServeHTTP(w, r)
+		return
+	}
+	s.proxy.ServeHTTP(w, r)
+}
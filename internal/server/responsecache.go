@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/config"
+)
+
+// maxCachedResponses bounds the number of entries kept by the response cache
+const maxCachedResponses = 4096
+
+// responseCache keeps GET responses in memory for the TTL of the first
+// Cache.Rules entry matching their content type
+type responseCache struct {
+	rules []config.CacheRule
+
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// cachedResponse is a stored response and the time it stops being served
+type cachedResponse struct {
+	path    string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(rules []config.CacheRule) *responseCache {
+	return &responseCache{rules: rules, entries: make(map[string]*cachedResponse)}
+}
+
+// ruleFor returns the first rule matching contentType
+func (c *responseCache) ruleFor(contentType string) (config.CacheRule, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return config.CacheRule{}, false
+	}
+
+	for _, rule := range c.rules {
+		if ok, _ := path.Match(rule.ContentType, mediaType); ok {
+			return rule, true
+		}
+	}
+	return config.CacheRule{}, false
+}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *responseCache) put(key string, entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCachedResponses {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedResponses {
+			return
+		}
+	}
+	c.entries[key] = entry
+}
+
+// invalidate drops the entries for any of paths, whatever their host or query
+func (c *responseCache) invalidate(paths map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if paths[entry.path] {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// middleware serves cached responses and stores cacheable new ones
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Stored responses may vary on Accept-Encoding, so clients that
+		// accept different codings never share an entry
+		key := r.Host + r.URL.RequestURI() + "\x00" + acceptedEncodings(r.Header)
+		if entry, ok := c.get(key); ok {
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(entry.status)
+			if r.Method == http.MethodGet {
+				w.Write(entry.body)
+			}
+			return
+		}
+
+		// Only complete GET responses are stored; HEAD has no body to keep
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &cacheRecorder{
+			ResponseWriter: w,
+			cache:          c,
+			credentialed:   r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "",
+		}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.cacheable {
+			c.put(key, &cachedResponse{
+				path:    r.URL.Path,
+				status:  recorder.status,
+				header:  recorder.header,
+				body:    recorder.body.Bytes(),
+				expires: time.Now().Add(recorder.rule.TTL),
+			})
+		}
+	})
+}
+
+// cacheRecorder passes the response through while keeping a copy of it when
+// it is eligible for caching
+type cacheRecorder struct {
+	http.ResponseWriter
+	cache *responseCache
+
+	// credentialed is set when the request carried Authorization or Cookie
+	credentialed bool
+
+	wroteHeader bool
+	cacheable   bool
+	rule        config.CacheRule
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+}
+
+func (w *cacheRecorder) WriteHeader(code int) {
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.wroteHeader = true
+		w.status = code
+		w.rule, w.cacheable = w.cache.ruleFor(w.Header().Get("Content-Type"))
+		if w.cacheable && !storable(code, w.Header(), w.credentialed) {
+			w.cacheable = false
+		}
+		if w.cacheable && w.rule.MaxSize > 0 {
+			if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && length > w.rule.MaxSize {
+				w.cacheable = false
+			}
+		}
+		if w.cacheable {
+			w.header = w.Header().Clone()
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.cacheable {
+		if w.rule.MaxSize > 0 && int64(w.body.Len()+len(b)) > w.rule.MaxSize {
+			w.cacheable = false
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush marks the response as streamed, which is never cached
+func (w *cacheRecorder) Flush() {
+	w.cacheable = false
+	w.body = bytes.Buffer{}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// storable reports whether a response may be shared between clients. As in
+// RFC 9111 section 3.5, a response to a request with credentials is only
+// shared when it is marked public or carries s-maxage.
+func storable(status int, header http.Header, credentialed bool) bool {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return false
+	}
+	if credentialed && !sharedExplicitly(cacheControl) {
+		return false
+	}
+
+	// Responses varying on anything but encoding depend on request headers
+	for _, vary := range header.Values("Vary") {
+		for _, field := range strings.Split(vary, ",") {
+			if field = strings.TrimSpace(field); field != "" && !strings.EqualFold(field, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// acceptedEncodings normalizes Accept-Encoding to the sorted, lowercased
+// codings the client accepts, so equivalent headers map to the same entry
+func acceptedEncodings(header http.Header) string {
+	var codings []string
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || slices.Contains(codings, name) {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					continue
+				}
+			}
+			codings = append(codings, name)
+		}
+	}
+	slices.Sort(codings)
+	return strings.Join(codings, ",")
+}
+
+// sharedExplicitly reports whether a lowercased Cache-Control value has the
+// public or s-maxage directive
+func sharedExplicitly(cacheControl string) bool {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name == "public" || name == "s-maxage" {
+			return true
+		}
+	}
+	return false
+}
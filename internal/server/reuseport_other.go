@@ -0,0 +1,19 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// reusePortControl is unsupported outside Linux
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("reuse_port is only supported on Linux")
+}
+
+// setListenBacklog is a no-op outside Linux; the OS default backlog is used
+func setListenBacklog(ln net.Listener, backlog int) error {
+	return nil
+}
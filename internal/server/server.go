@@ -4,62 +4,278 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
-	"net/http/httputil"
-    "net/url"
 
 	"github.com/featherjet/featherjet/internal/config"
 	"github.com/featherjet/featherjet/internal/middleware"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/yaml.v3"
 )
 
+// Version is the FeatherJet release version
+const Version = "1.0.0"
+
 // Server represents the FeatherJet HTTP server
 type Server struct {
-	config     *config.Config
-	httpServer *http.Server
-	mux        *http.ServeMux
+	config            *config.Config
+	httpServer        *http.Server
+	mux               *http.ServeMux
+	accessLogFormat   *middleware.AccessLogFormat
+	logStatusFilter   middleware.StatusFilter
+	listener          net.Listener
+	proxy             *httputil.ReverseProxy
+	proxyTransport    *http.Transport
+	grpcWebProxy      *httputil.ReverseProxy
+	balancer          *weightedBalancer
+	draining          atomic.Bool
+	metrics           *middleware.Metrics
+	startTime         time.Time
+	autocertManager   *autocert.Manager
+	challengeServer   *http.Server
+	adminServer       *http.Server
+	adminListener     net.Listener
+	challengeListener net.Listener
+	http3Server       *http3.Server
+	http3Conn         net.PacketConn
+	http3Done         chan struct{}
+	accessLogFile     *rotatingFile
+	stopConnRefresh   func()
+	stopStaticWatch   func()
+	maintenance       atomic.Bool
+	maintenanceAllow  []*net.IPNet
+	trustedProxies    []*net.IPNet
+	canaryAllow       []*net.IPNet
+	canaryBackends    map[string]func(*http.Request)
+	cachePolicy       atomic.Pointer[cachePolicy]
+	fileLoader        *fileLoader
+	responseCache     *responseCache
+	streams           streamRegistry
+	middlewareNames   []string
+	logger            *slog.Logger
+
+	blockedUserAgents []*regexp.Regexp
+	contextHeaders    []middleware.ContextHeader
 }
 
-// New creates a new FeatherJet server instance
-func New(cfg *config.Config) *Server {
-	if err := cfg.Validate(); err != nil {
-		panic(fmt.Sprintf("Invalid configuration: %v", err))
+// New creates a new FeatherJet server instance. It returns an error when the
+// configuration is invalid.
+func New(cfg *config.Config, opts ...Option) (*Server, error) {
+	warnings, err := cfg.ValidateWithWarnings()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logStatusFilter, err := middleware.ParseStatusFilter(cfg.Logging.StatusFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	var accessLogFormat *middleware.AccessLogFormat
+	if cfg.Logging.AccessLogFormat != "" {
+		format, err := middleware.ParseAccessLogFormat(cfg.Logging.AccessLogFormat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+		format.SetTimeFormatter(logTimeFormatter(cfg, "02/Jan/2006:15:04:05 -0700"))
+		format.SetQueryFilter(logQueryFilter(cfg))
+		format.SetStatusFilter(logStatusFilter)
+		accessLogFormat = format
+	}
+
+	blockedUserAgents, err := middleware.CompileUserAgentPatterns(cfg.Middleware.BlockedUserAgents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	contextHeaders, err := compileContextHeaders(cfg.Middleware.ContextHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	mux := http.NewServeMux()
-	
+
 	server := &Server{
-		config: cfg,
-		mux:    mux,
+		config:            cfg,
+		mux:               mux,
+		accessLogFormat:   accessLogFormat,
+		logStatusFilter:   logStatusFilter,
+		blockedUserAgents: blockedUserAgents,
+		contextHeaders:    contextHeaders,
+		metrics:           middleware.NewMetrics(),
+		startTime:         time.Now(),
 		httpServer: &http.Server{
-			Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+			Addr:         net.JoinHostPort(strings.Trim(cfg.Server.Host, "[]"), strconv.Itoa(cfg.Server.Port)),
 			ReadTimeout:  cfg.Server.ReadTimeout,
 			WriteTimeout: cfg.Server.WriteTimeout,
 			IdleTimeout:  cfg.Server.IdleTimeout,
+
+			ReadHeaderTimeout: cfg.Server.HeaderReadDeadline,
 		},
 	}
 
+	server.maintenanceAllow, err = parseCIDRs(cfg.Server.MaintenanceAllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: maintenance_allow_cidrs: %w", err)
+	}
+	server.maintenance.Store(cfg.Server.Maintenance)
+	server.cachePolicy.Store(newCachePolicy(cfg))
+
+	server.trustedProxies, err = parseCIDRs(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: trusted_proxies: %w", err)
+	}
+
+	server.canaryAllow, err = parseCIDRs(cfg.Proxy.CanaryAllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: canary_allow_cidrs: %w", err)
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.logger == nil {
+		server.logger = newLogger(cfg, os.Stderr)
+	}
+	server.httpServer.ErrorLog = slog.NewLogLogger(server.logger.Handler(), slog.LevelError)
+
+	for _, warning := range warnings {
+		server.logger.Warn("configuration warning: " + warning)
+	}
+
+	if cfg.AutoCertEnabled() {
+		server.setupAutoCert()
+	}
+	server.configureTLS()
+
+	if cfg.Server.EnableHTTP3 {
+		server.setupHTTP3()
+	}
+
+	if cfg.Server.AdminAddr != "" {
+		server.setupAdmin()
+	}
+
+	if cfg.ProxyEnabled() {
+		proxy, err := server.newTasksProxy()
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+		server.proxy = proxy
+	}
+
+	if cfg.Logging.EnableRequestLogging && cfg.Logging.AccessLogFile != "" {
+		maxSize := int64(cfg.Logging.AccessLogMaxSizeMB) << 20
+		file, err := openRotatingFile(cfg.Logging.AccessLogFile, maxSize, cfg.Logging.CompressRotated)
+		if err != nil {
+			return nil, err
+		}
+		file.onCompressError = func(err error) {
+			server.logger.Error("access log rotation failed", "error", err)
+		}
+		server.accessLogFile = file
+	}
+
+	if cfg.Static.RequireDirectory {
+		info, err := os.Stat(cfg.Static.Directory)
+		if err != nil {
+			return nil, fmt.Errorf("static directory %s is required: %w", cfg.Static.Directory, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("static directory %s is required but is not a directory", cfg.Static.Directory)
+		}
+	}
+
+	if cfg.Cache.SingleFlight {
+		server.fileLoader = newFileLoader()
+	}
+
+	if len(cfg.Cache.Rules) > 0 {
+		server.responseCache = newResponseCache(cfg.Cache.Rules)
+	}
+
+	if cfg.Static.PrecompressOnStart {
+		if err := server.precompressStatic(); err != nil {
+			server.logger.Warn("static precompression incomplete", "error", err)
+		}
+	}
+
+	if interval := cfg.Static.WatchInterval; interval > 0 {
+		server.stopStaticWatch = server.watchStatic(interval)
+	}
+
 	server.setupRoutes()
 	server.setupMiddleware()
 
-	return server
+	return server, nil
 }
 
-func (s *Server) handleTasksProxy(w http.ResponseWriter, r *http.Request) {
-    target, _ := url.Parse("http://localhost:8080") // Replace PORT with VelocityTasks port
-    proxy := httputil.NewSingleHostReverseProxy(target)
-    proxy.ServeHTTP(w, r)
+// MustNew is like New but panics when the configuration is invalid.
+//
+// Deprecated: use New and handle the returned error.
+func MustNew(cfg *config.Config, opts ...Option) *Server {
+	server, err := New(cfg, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return server
 }
 
 // setupRoutes configures the server routes
 func (s *Server) setupRoutes() {
-	// API routes
-	s.mux.HandleFunc("/api/hello", s.handleHello)
-	s.mux.HandleFunc("/api/status", s.handleStatus)
-	s.mux.HandleFunc("/api/info", s.handleInfo)
-	s.mux.HandleFunc("/api/tasks/", s.handleTasksProxy)
-	s.mux.HandleFunc("/api/tasks", s.handleTasksProxy)  // Proxy to VelocityTasks
+	// API routes; disabled built-ins fall through to the API 404 handler
+	builtins := map[string]http.HandlerFunc{
+		"hello":  s.handleHello,
+		"status": s.handleStatus,
+		"info":   s.handleInfo,
+	}
+	for _, name := range s.config.API.DisabledEndpoints {
+		delete(builtins, name)
+	}
+	for name, handler := range builtins {
+		s.mux.HandleFunc("/api/"+name, handler)
+	}
+	s.mux.HandleFunc("/api/readyz", s.handleReadyz)
+	// With an admin listener, public clients cannot reach the drain switch
+	if s.config.Server.AdminAddr == "" {
+		s.mux.HandleFunc("/api/drain", s.handleDrain)
+	}
+	for path, endpoint := range s.config.API.Custom {
+		s.mux.HandleFunc(path, customEndpointHandler(endpoint))
+	}
+	// Without the proxy these paths fall through to the API 404 handler
+	if s.config.ProxyEnabled() {
+		s.mux.HandleFunc("/api/tasks/", s.handleTasksProxy)
+		s.mux.HandleFunc("/api/tasks", s.handleTasksProxy) // Proxy to VelocityTasks
+	}
+
+	if s.config.Server.EnableExpvar {
+		s.mux.HandleFunc("/debug/vars", s.handleExpvar)
+	}
+
+	// Inline well-known files take precedence over the static directory
+	if s.config.Static.RobotsTxt != "" {
+		s.mux.HandleFunc("/robots.txt", inlineTextHandler(s.config.Static.RobotsTxt))
+	}
+	if s.config.Static.SecurityTxt != "" {
+		s.mux.HandleFunc("/.well-known/security.txt", inlineTextHandler(s.config.Static.SecurityTxt))
+	}
+	if s.config.Static.GenerateSitemap {
+		s.mux.HandleFunc("/sitemap.xml", newSitemap(s.config).handler(s.isHTTPS))
+	}
+	if s.config.Static.EmitSRI {
+		s.mux.HandleFunc("/api/sri", s.handleSRI(newSRIIndex(s.config)))
+	}
 
 	// Static file handler
 	staticHandler := s.createStaticFileHandler()
@@ -68,19 +284,16 @@ func (s *Server) setupRoutes() {
 
 // setupMiddleware configures middleware chain
 func (s *Server) setupMiddleware() {
-	var handler http.Handler = s.mux
-
-	// Add security headers
-	handler = middleware.Security(handler)
+	chain := s.middlewareChain()
 
-	// Add CORS if enabled
-	if s.config.Middleware.EnableCORS {
-		handler = middleware.CORS(handler)
+	var handler http.Handler = s.mux
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i].wrap(handler)
 	}
 
-	// Add request logging if enabled
-	if s.config.Logging.EnableRequestLogging {
-		handler = middleware.Logger(handler)
+	s.middlewareNames = make([]string, len(chain))
+	for i, m := range chain {
+		s.middlewareNames[i] = m.name
 	}
 
 	s.httpServer.Handler = handler
@@ -89,36 +302,164 @@ func (s *Server) setupMiddleware() {
 // createStaticFileHandler creates a handler for serving static files
 func (s *Server) createStaticFileHandler() http.Handler {
 	staticDir := s.config.Static.Directory
-	
+
 	// Ensure static directory exists
 	if _, err := os.Stat(staticDir); os.IsNotExist(err) {
-		fmt.Printf("Warning: Static directory %s does not exist\n", staticDir)
+		s.logger.Warn("static directory does not exist", "directory", staticDir)
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.serveWelcomePage(w, r) {
+				return
+			}
 			http.Error(w, "Static directory not found", http.StatusNotFound)
 		})
 	}
 
 	fileServer := http.FileServer(http.Dir(staticDir))
-	
+
+	absStaticDir, err := filepath.Abs(staticDir)
+	if err != nil {
+		absStaticDir = staticDir
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set cache headers for static files
-		if s.config.Static.CacheMaxAge != "" {
-			w.Header().Set("Cache-Control", "max-age="+s.config.Static.CacheMaxAge)
+		// Unknown API routes never fall through to the file server
+		if isAPIPath(r.URL.Path) {
+			s.handleAPINotFound(w, r)
+			return
 		}
 
-		// Check if it's an API route
-		if len(r.URL.Path) >= 4 && r.URL.Path[:4] == "/api" {
+		// First-run deployments get a welcome page instead of an empty listing
+		if s.serveWelcomePage(w, r) {
+			return
+		}
+
+		// Legacy URLs are redirected before any file lookup
+		if target, status, ok := matchRedirect(s.config.Static.Redirects, r.URL.Path); ok {
+			if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, status)
+			return
+		}
+
+		// Internal rewrites change which file is served, not the URL
+		r = rewriteRequest(s.config.Static.Rewrites, r)
+
+		// Never expose .env, .git/ and similar files
+		if s.config.Static.BlockDotfiles && isBlockedDotfile(r.URL.Path, s.config.Static.DotfileAllowlist) {
 			http.NotFound(w, r)
 			return
 		}
 
+		// Localized pages are picked by Accept-Language
+		if s.config.Static.LanguageNegotiation {
+			r = s.negotiateLanguage(w, r, absStaticDir)
+		}
+
+		// Set cache headers for static files
+		if cacheControl := s.cacheControlFor(r.URL.Path); cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+
+		// Operator headers override both the security and cache defaults
+		for name, value := range s.config.Static.ResponseHeaders {
+			w.Header().Set(name, value)
+		}
+
+		if s.logger.Enabled(r.Context(), slog.LevelDebug) {
+			resolved, decision := resolveStaticPath(absStaticDir, r.URL.Path)
+			s.logger.Debug("static file resolved", "path", r.URL.Path, "file", resolved, "decision", decision)
+		}
+
+		// Sorted listings replace the file server's name-ordered one
+		if s.config.Static.ListingSort != "" && strings.HasSuffix(r.URL.Path, "/") {
+			if resolved, decision := resolveStaticPath(absStaticDir, r.URL.Path); decision == "directory listing" {
+				s.serveDirectoryListing(w, r, resolved)
+				return
+			}
+		}
+
+		// Warmed .gz variants skip runtime compression
+		if s.config.Static.PrecompressOnStart && servePrecompressed(w, r, absStaticDir, r.URL.Path) {
+			return
+		}
+
 		// Serve the file or directory listing
+		w = &charsetResponseWriter{ResponseWriter: w, charset: s.config.Static.DefaultCharset}
+
+		// Concurrent requests for the same file share a single read
+		if s.fileLoader != nil && s.serveCoalesced(w, r, absStaticDir) {
+			return
+		}
 		fileServer.ServeHTTP(w, r)
 	})
 }
 
 // API Handlers
 
+// writeJSON encodes response as JSON with an exact Content-Length, indented
+// when API.PrettyJSON is set. HEAD requests get the same headers without the body.
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, response interface{}) {
+	var body []byte
+	var err error
+	if s.config.API.PrettyJSON {
+		body, err = json.MarshalIndent(response, "", "  ")
+	} else {
+		body, err = json.Marshal(response)
+	}
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, "application/json", append(body, '\n'))
+}
+
+// writeNegotiated writes response as YAML when the client asks for it and as
+// JSON otherwise
+func (s *Server) writeNegotiated(w http.ResponseWriter, r *http.Request, response interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if !prefersYAML(r) {
+		s.writeJSON(w, r, response)
+		return
+	}
+
+	body, err := yaml.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, r, "application/yaml", body)
+}
+
+// writeBody writes an encoded API response, omitting the body for HEAD
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Write(body)
+}
+
+// customEndpointHandler serves a configured API.Custom response to every method
+func customEndpointHandler(endpoint config.CustomEndpoint) http.HandlerFunc {
+	status := endpoint.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := []byte(endpoint.Body + "\n")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+	}
+}
+
 // handleHello responds to /api/hello
 func (s *Server) handleHello(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
@@ -128,10 +469,7 @@ func (s *Server) handleHello(w http.ResponseWriter, r *http.Request) {
 		"path":      r.URL.Path,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-	}
+	s.writeJSON(w, r, response)
 }
 
 // handleStatus responds to /api/status
@@ -139,15 +477,13 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":    "healthy",
 		"server":    "FeatherJet",
-		"version":   "1.0.0",
+		"version":   Version,
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"uptime":    time.Since(time.Now()).String(), // This would be calculated from server start time in production
+		"uptime":    time.Since(s.startTime).Round(time.Second).String(),
+		"requests":  s.metrics.Snapshot(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-	}
+	s.writeNegotiated(w, r, response)
 }
 
 // handleInfo responds to /api/info
@@ -166,7 +502,7 @@ func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"server": map[string]interface{}{
 			"name":    "FeatherJet",
-			"version": "1.0.0",
+			"version": Version,
 			"host":    s.config.Server.Host,
 			"port":    s.config.Server.Port,
 		},
@@ -175,23 +511,110 @@ func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
 			"cors_enabled":        s.config.Middleware.EnableCORS,
 			"compression_enabled": s.config.Middleware.EnableCompression,
 			"request_logging":     s.config.Logging.EnableRequestLogging,
+			"chain":               s.middlewareNames,
 		},
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if s.balancer != nil {
+		response["proxy"] = map[string]interface{}{
+			"targets": s.balancer.weights(),
+		}
 	}
+
+	s.writeNegotiated(w, r, response)
 }
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
-	fmt.Printf("FeatherJet server listening on %s\n", s.httpServer.Addr)
-	return s.httpServer.ListenAndServe()
+	ln, err := listenOrInherit(listenerFDEnv, s.httpServer.Addr, s.listenConfig())
+	if err != nil {
+		return err
+	}
+
+	if backlog := s.config.Server.ListenBacklog; backlog > 0 {
+		if err := setListenBacklog(ln, backlog); err != nil {
+			s.logger.Warn("failed to set listen backlog", "backlog", backlog, "error", err)
+		}
+	}
+	s.listener = ln
+
+	// Restart hands off s.listener, so only the served listener is wrapped
+	var served net.Listener = ln
+	if period := s.config.Server.TCPKeepAlive; period != 0 {
+		served = keepAliveListener{Listener: ln, period: period}
+	}
+	if max := s.config.Server.MaxConnectionsPerIP; max > 0 {
+		served = newPerIPListener(served, max, s.logger)
+	}
+	if s.config.Server.ProxyProtocol {
+		served = proxyProtocolListener{Listener: served}
+	}
+	// Header bytes are only visible before TLS, so TLS relies on
+	// ReadHeaderTimeout alone
+	if deadline := s.config.Server.HeaderReadDeadline; deadline > 0 && !s.config.TLSEnabled() {
+		served = headerDeadlineListener{Listener: served, timeout: deadline}
+	}
+
+	s.logStartup(ln.Addr().String())
+
+	if s.adminServer != nil {
+		if err := s.startAdmin(); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	if s.challengeServer != nil {
+		s.startChallenge()
+	}
+
+	if s.http3Server != nil {
+		s.startHTTP3()
+	}
+
+	if s.config.TLSEnabled() {
+		// With autocert the certificate comes from TLSConfig.GetCertificate
+		return s.httpServer.ServeTLS(served, s.config.TLS.CertFile, s.config.TLS.KeyFile)
+	}
+	return s.httpServer.Serve(served)
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. Public traffic stops first and
+// drains, then the ACME challenge server, and the admin listener goes last so
+// it keeps reporting status and readiness throughout the drain.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	s.BeginDrain()
+
+	if grace := s.config.Server.StreamShutdownGrace; grace > 0 {
+		s.closeStreams(grace)
+	}
+
+	err := s.httpServer.Shutdown(ctx)
+
+	if s.http3Conn != nil {
+		s.stopHTTP3()
+	}
+
+	if s.challengeServer != nil {
+		s.challengeServer.Shutdown(ctx)
+	}
+
+	if s.adminServer != nil {
+		s.adminServer.Shutdown(ctx)
+	}
+
+	if s.accessLogFile != nil {
+		s.accessLogFile.Close()
+	}
+
+	if s.stopConnRefresh != nil {
+		s.stopConnRefresh()
+	}
+
+	if s.stopStaticWatch != nil {
+		s.stopStaticWatch()
+	}
+
+	return err
 }
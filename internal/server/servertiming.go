@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/middleware"
+)
+
+// upstreamStartKey stores when a proxied request was handed to the backend
+type upstreamStartKey struct{}
+
+// serverTimingDur formats d as a Server-Timing metric in milliseconds
+func serverTimingDur(name string, d time.Duration) string {
+	return name + ";dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// serverTiming adds a Server-Timing "handler" metric with the time taken
+// until the response headers were written, so browsers can show it
+func (s *Server) serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&serverTimingWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
+
+// markUpstreamStart records the start of the upstream leg for
+// recordUpstreamTiming
+func markUpstreamStart(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), upstreamStartKey{}, time.Now()))
+}
+
+// recordUpstreamTiming measures how long the backend took to send its
+// response headers, adding it as a Server-Timing "upstream" metric when resp
+// is set and handing it to the request logger. It is also called with a nil
+// resp for requests that failed upstream.
+func (s *Server) recordUpstreamTiming(r *http.Request, resp *http.Response) {
+	start, ok := r.Context().Value(upstreamStartKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start)
+
+	if resp != nil && s.config.Server.EmitServerTiming {
+		resp.Header.Add("Server-Timing", serverTimingDur("upstream", elapsed))
+	}
+	middleware.RecordUpstream(r.Context(), elapsed)
+}
+
+// serverTimingWriter stamps the handler duration when headers are written
+type serverTimingWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (w *serverTimingWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.wroteHeader = true
+		w.Header().Add("Server-Timing", serverTimingDur("handler", time.Since(w.start)))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serverTimingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer so streaming responses still work
+func (w *serverTimingWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *serverTimingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
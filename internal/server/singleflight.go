@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// fileLoader coalesces concurrent reads of the same file: while one read is
+// in flight, other requests for that path wait for and share its result
+// instead of hitting the disk again
+type fileLoader struct {
+	mu       sync.Mutex
+	inflight map[string]*fileLoad
+	readFile func(name string) ([]byte, error)
+}
+
+// fileLoad is a read shared by every request that arrived while it ran
+type fileLoad struct {
+	done sync.WaitGroup
+	data []byte
+	err  error
+}
+
+func newFileLoader() *fileLoader {
+	return &fileLoader{
+		inflight: make(map[string]*fileLoad),
+		readFile: os.ReadFile,
+	}
+}
+
+// load returns the contents of name, joining a read already in progress
+func (l *fileLoader) load(name string) ([]byte, error) {
+	l.mu.Lock()
+	if call, ok := l.inflight[name]; ok {
+		l.mu.Unlock()
+		call.done.Wait()
+		return call.data, call.err
+	}
+
+	call := &fileLoad{}
+	call.done.Add(1)
+	l.inflight[name] = call
+	l.mu.Unlock()
+
+	call.data, call.err = l.readFile(name)
+
+	l.mu.Lock()
+	delete(l.inflight, name)
+	l.mu.Unlock()
+	call.done.Done()
+
+	return call.data, call.err
+}
+
+// serveCoalesced serves a regular file no larger than Cache.MaxFileSizeMB
+// through the shared loader. It reports false when the request should fall
+// through to the file server instead.
+func (s *Server) serveCoalesced(w http.ResponseWriter, r *http.Request, root string) bool {
+	resolved, decision := resolveStaticPath(root, r.URL.Path)
+	if decision != "served file" {
+		return false
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil || info.Size() > int64(s.config.Cache.MaxFileSizeMB)<<20 {
+		return false
+	}
+
+	data, err := s.fileLoader.load(resolved)
+	if err != nil {
+		return false
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(data))
+	return true
+}
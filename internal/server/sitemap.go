@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/config"
+)
+
+// sitemapEntry is one HTML page listed in the generated sitemap
+type sitemapEntry struct {
+	path    string
+	modTime time.Time
+}
+
+// sitemap generates /sitemap.xml from the HTML files under the static root.
+// Entries are cached until a directory under the root changes, which is when
+// pages are added, removed or renamed.
+type sitemap struct {
+	root             string
+	blockDotfiles    bool
+	dotfileAllowlist []string
+
+	mu          sync.Mutex
+	fingerprint string
+	entries     []sitemapEntry
+}
+
+func newSitemap(cfg *config.Config) *sitemap {
+	return &sitemap{
+		root:             cfg.Static.Directory,
+		blockDotfiles:    cfg.Static.BlockDotfiles,
+		dotfileAllowlist: cfg.Static.DotfileAllowlist,
+	}
+}
+
+// dirFingerprint summarizes the modification times of every directory under
+// the root without statting individual files
+func (m *sitemap) dirFingerprint() string {
+	var b strings.Builder
+	filepath.WalkDir(m.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			b.WriteString(p)
+			b.WriteByte(0)
+			b.WriteString(strconv.FormatInt(info.ModTime().UnixNano(), 10))
+			b.WriteByte(0)
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// scan lists the HTML pages under the root by their URL paths, with
+// index.html pages listed as their directory
+func (m *sitemap) scan() []sitemapEntry {
+	var entries []sitemapEntry
+	filepath.WalkDir(m.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(m.root, p)
+		if err != nil {
+			return nil
+		}
+		urlPath := "/" + filepath.ToSlash(rel)
+		if rel == "." {
+			urlPath = "/"
+		}
+		if m.blockDotfiles && isBlockedDotfile(urlPath, m.dotfileAllowlist) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(p), ".html") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if dir, file := path.Split(urlPath); file == "index.html" {
+			urlPath = dir
+		}
+		entries = append(entries, sitemapEntry{path: urlPath, modTime: info.ModTime()})
+		return nil
+	})
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries
+}
+
+// current returns the cached entries, rescanning when a directory changed
+func (m *sitemap) current() []sitemapEntry {
+	fingerprint := m.dirFingerprint()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.entries == nil || fingerprint != m.fingerprint {
+		m.entries = m.scan()
+		m.fingerprint = fingerprint
+	}
+	return m.entries
+}
+
+// sitemapURLSet is the XML document served at /sitemap.xml
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// handler serves the sitemap with absolute URLs for the requested host
+func (m *sitemap) handler(isHTTPS func(*http.Request) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if isHTTPS(r) {
+			scheme = "https"
+		}
+
+		urlSet := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+		for _, entry := range m.current() {
+			urlSet.URLs = append(urlSet.URLs, sitemapURL{
+				Loc:     scheme + "://" + r.Host + entry.path,
+				LastMod: entry.modTime.UTC().Format(time.RFC3339),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(urlSet)
+	}
+}
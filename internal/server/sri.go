@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/config"
+)
+
+// sriExtensions are the assets browsers check integrity attributes on
+var sriExtensions = map[string]bool{".js": true, ".mjs": true, ".css": true}
+
+// sriHash is a computed integrity value and the file state it was computed for
+type sriHash struct {
+	modTime   time.Time
+	size      int64
+	integrity string
+}
+
+// sriIndex reports subresource integrity hashes for the scripts and
+// stylesheets under the static root. Hashes are computed on first request
+// and reused until the file's size or modification time changes.
+type sriIndex struct {
+	root             string
+	blockDotfiles    bool
+	dotfileAllowlist []string
+
+	mu     sync.Mutex
+	hashes map[string]sriHash
+}
+
+func newSRIIndex(cfg *config.Config) *sriIndex {
+	return &sriIndex{
+		root:             cfg.Static.Directory,
+		blockDotfiles:    cfg.Static.BlockDotfiles,
+		dotfileAllowlist: cfg.Static.DotfileAllowlist,
+		hashes:           make(map[string]sriHash),
+	}
+}
+
+// fileIntegrity returns the "sha384-..." integrity value of file
+func fileIntegrity(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha512.New384()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// integrity returns the cached hash for urlPath, recomputing it when the file changed
+func (x *sriIndex) integrity(urlPath, file string, info fs.FileInfo) (string, error) {
+	x.mu.Lock()
+	cached, ok := x.hashes[urlPath]
+	x.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.integrity, nil
+	}
+
+	integrity, err := fileIntegrity(file)
+	if err != nil {
+		return "", err
+	}
+
+	x.mu.Lock()
+	x.hashes[urlPath] = sriHash{modTime: info.ModTime(), size: info.Size(), integrity: integrity}
+	x.mu.Unlock()
+	return integrity, nil
+}
+
+// current maps the URL path of every script and stylesheet to its integrity
+// value, forgetting hashes of files that no longer exist
+func (x *sriIndex) current() map[string]string {
+	hashes := make(map[string]string)
+	filepath.WalkDir(x.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(x.root, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		urlPath := "/" + filepath.ToSlash(rel)
+		if x.blockDotfiles && isBlockedDotfile(urlPath, x.dotfileAllowlist) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !sriExtensions[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if integrity, err := x.integrity(urlPath, p, info); err == nil {
+			hashes[urlPath] = integrity
+		}
+		return nil
+	})
+
+	x.mu.Lock()
+	for urlPath := range x.hashes {
+		if _, ok := hashes[urlPath]; !ok {
+			delete(x.hashes, urlPath)
+		}
+	}
+	x.mu.Unlock()
+	return hashes
+}
+
+// handleSRI responds to /api/sri with {"/app.js": "sha384-...", ...}
+func (s *Server) handleSRI(index *sriIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writeJSON(w, r, index.current())
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"time"
+)
+
+// sseIdleTimeout lets text/event-stream responses outlive the server's
+// read and write timeouts. Each event pushes the connection deadlines
+// Server.SSEIdleTimeout into the future, so a stream is only cut off once it
+// has been silent for that long.
+func (s *Server) sseIdleTimeout(next http.Handler) http.Handler {
+	timeout := s.config.Server.SSEIdleTimeout
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&sseDeadlineWriter{
+			ResponseWriter: w,
+			controller:     http.NewResponseController(w),
+			timeout:        timeout,
+		}, r)
+	})
+}
+
+// sseDeadlineWriter extends the connection deadlines on every write once the
+// response turns out to be an event stream
+type sseDeadlineWriter struct {
+	http.ResponseWriter
+	controller  *http.ResponseController
+	timeout     time.Duration
+	wroteHeader bool
+	stream      bool
+}
+
+func (w *sseDeadlineWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.wroteHeader = true
+		mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		w.stream = mediaType == "text/event-stream"
+	}
+	w.extendDeadlines()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sseDeadlineWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.extendDeadlines()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer so events reach the client
+func (w *sseDeadlineWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.extendDeadlines()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *sseDeadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *sseDeadlineWriter) extendDeadlines() {
+	if !w.stream {
+		return
+	}
+	deadline := time.Now().Add(w.timeout)
+	w.controller.SetReadDeadline(deadline)
+	w.controller.SetWriteDeadline(deadline)
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"runtime"
+)
+
+// goroutineStacks returns the stack traces of all goroutines, growing the
+// buffer until every trace fits
+func goroutineStacks() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// LogStacks writes every goroutine's stack trace to the server log without
+// stopping the process, for diagnosing hangs in production
+func (s *Server) LogStacks() {
+	s.logger.Warn("goroutine stack dump", "goroutines", runtime.NumGoroutine(), "stacks", goroutineStacks())
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// proxyTarget describes where /api/tasks is proxied to
+func (s *Server) proxyTarget() string {
+	if !s.config.ProxyEnabled() {
+		return ""
+	}
+	if targets := s.config.Proxy.Targets; len(targets) > 0 {
+		urls := make([]string, len(targets))
+		for i, target := range targets {
+			urls[i] = target.URL
+		}
+		return strings.Join(urls, ",")
+	}
+	return s.config.Proxy.Target
+}
+
+// startupAttrs describes the running server for the startup log
+func (s *Server) startupAttrs(addr string) []any {
+	return []any{
+		"version", Version,
+		"listen_address", addr,
+		"static_dir", s.config.Static.Directory,
+		"tls", s.config.TLSEnabled(),
+		"proxy_target", s.proxyTarget(),
+		"middleware", s.middlewareNames,
+	}
+}
+
+// logStartup emits a single startup event: a structured "startup" record when
+// the log format is json, or a human-readable banner otherwise
+func (s *Server) logStartup(addr string) {
+	if s.config.Logging.Format == "json" {
+		s.logger.Info("startup", s.startupAttrs(addr)...)
+		return
+	}
+
+	scheme := "http"
+	if s.config.TLSEnabled() {
+		scheme = "https"
+	}
+
+	proxyTarget := s.proxyTarget()
+	if proxyTarget == "" {
+		proxyTarget = "disabled"
+	}
+
+	s.logger.Info(fmt.Sprintf("FeatherJet %s listening on %s://%s", Version, scheme, addr),
+		"static", s.config.Static.Directory,
+		"proxy", proxyTarget,
+		"middleware", strings.Join(s.middlewareNames, ","),
+		"log_level", s.config.Logging.Level,
+	)
+}
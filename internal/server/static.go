@@ -0,0 +1,172 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/config"
+)
+
+// resolveStaticPath maps a URL path to the file it resolves to under root and
+// describes what the file server will do with it
+func resolveStaticPath(root, urlPath string) (string, string) {
+	resolved := filepath.Join(root, filepath.FromSlash(path.Clean("/"+urlPath)))
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return resolved, "404"
+	}
+
+	if !info.IsDir() {
+		return resolved, "served file"
+	}
+
+	index := filepath.Join(resolved, "index.html")
+	if _, err := os.Stat(index); err == nil {
+		return index, "served index"
+	}
+
+	return resolved, "directory listing"
+}
+
+// isBlockedDotfile reports whether any segment of the URL path is a dotfile or
+// dot-directory (e.g. .env, .git/) that is not explicitly allowlisted
+func isBlockedDotfile(urlPath string, allowlist []string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if !strings.HasPrefix(segment, ".") || segment == "." || segment == ".." {
+			continue
+		}
+
+		allowed := false
+		for _, entry := range allowlist {
+			if segment == strings.Trim(entry, "/") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapPath applies a from/to mapping to urlPath. An exact from is matched
+// literally; "/old/*" matches the prefix and carries the remaining path over
+// to a "*" in to.
+func mapPath(from, to, urlPath string) (string, bool) {
+	prefix, isPrefix := strings.CutSuffix(from, "*")
+	if !isPrefix {
+		return to, urlPath == from
+	}
+
+	rest, ok := strings.CutPrefix(urlPath, prefix)
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(to, "*", rest, 1), true
+}
+
+// matchRedirect finds the first redirect for urlPath and returns its target
+func matchRedirect(redirects []config.Redirect, urlPath string) (string, int, bool) {
+	for _, redirect := range redirects {
+		if target, ok := mapPath(redirect.From, redirect.To, urlPath); ok {
+			status := redirect.Status
+			if status == 0 {
+				status = http.StatusMovedPermanently
+			}
+			return target, status, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// rewriteRequest returns r with its path changed by the first matching
+// rewrite, leaving the client-visible URL untouched
+func rewriteRequest(rewrites []config.Rewrite, r *http.Request) *http.Request {
+	for _, rewrite := range rewrites {
+		target, ok := mapPath(rewrite.From, rewrite.To, r.URL.Path)
+		if !ok {
+			continue
+		}
+		return withURLPath(r, target)
+	}
+
+	return r
+}
+
+// withURLPath returns a shallow copy of r that requests urlPath instead
+func withURLPath(r *http.Request, urlPath string) *http.Request {
+	rewritten := new(http.Request)
+	*rewritten = *r
+	rewritten.URL = new(url.URL)
+	*rewritten.URL = *r.URL
+	rewritten.URL.Path = urlPath
+	rewritten.URL.RawPath = ""
+	return rewritten
+}
+
+// inlineTextHandler serves fixed plain-text content such as robots.txt
+func inlineTextHandler(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}
+}
+
+// charsetResponseWriter appends a charset to text and JSON responses that
+// were served without one
+type charsetResponseWriter struct {
+	http.ResponseWriter
+	charset     string
+	wroteHeader bool
+}
+
+func (w *charsetResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.wroteHeader = true
+		if contentType := w.Header().Get("Content-Type"); contentType != "" {
+			w.Header().Set("Content-Type", withCharset(contentType, w.charset))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *charsetResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// jsonCharset is always used for JSON, which RFC 8259 requires to be UTF-8
+const jsonCharset = "utf-8"
+
+// withCharset appends a charset to a text/* or application/json content type
+// that lacks one. JSON always gets jsonCharset; text types get charset, and
+// are left alone when it is empty.
+func withCharset(contentType, charset string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if _, ok := params["charset"]; ok {
+		return contentType
+	}
+
+	switch {
+	case mediaType == "application/json":
+		charset = jsonCharset
+	case !strings.HasPrefix(mediaType, "text/") || charset == "":
+		return contentType
+	}
+	return contentType + "; charset=" + charset
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/featherjet/featherjet/internal/middleware"
+)
+
+// fileStamp is what the static watcher compares between scans
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// scanStatic stamps every file under root by slash-separated relative path.
+// Precompressed .gz copies are derived files and are left out.
+func scanStatic(root string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(file, ".gz") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return nil
+		}
+		stamps[filepath.ToSlash(rel)] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return stamps
+}
+
+// changedStaticFiles lists the files added, removed or modified between two scans
+func changedStaticFiles(previous, current map[string]fileStamp) []string {
+	var changed []string
+	for name, stamp := range current {
+		if old, ok := previous[name]; !ok || old != stamp {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// watchStatic rescans the static directory every interval and refreshes what
+// was derived from changed files. It returns a function stopping the watch.
+func (s *Server) watchStatic(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	previous := scanStatic(s.config.Static.Directory)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				current := scanStatic(s.config.Static.Directory)
+				if changed := changedStaticFiles(previous, current); len(changed) > 0 {
+					s.staticFilesChanged(changed)
+				}
+				previous = current
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// staticFilesChanged drops cached responses for the changed files and, when
+// the static directory is precompressed, refreshes their .gz copies
+func (s *Server) staticFilesChanged(files []string) {
+	s.logger.Debug("static files changed", "count", len(files))
+
+	if s.responseCache != nil {
+		paths := make(map[string]bool, len(files))
+		for _, file := range files {
+			urlPath := "/" + file
+			paths[urlPath] = true
+			if path.Base(urlPath) == "index.html" {
+				dir := path.Dir(urlPath)
+				paths[dir] = true
+				paths[strings.TrimSuffix(dir, "/")+"/"] = true
+			}
+		}
+		s.responseCache.invalidate(paths)
+	}
+
+	if !s.config.Static.PrecompressOnStart {
+		return
+	}
+	for _, file := range files {
+		name := filepath.Join(s.config.Static.Directory, filepath.FromSlash(file))
+		if _, err := os.Stat(name); err != nil {
+			continue
+		}
+		if !middleware.Compressible(mime.TypeByExtension(filepath.Ext(name))) || gzipUpToDate(name) {
+			continue
+		}
+		if err := gzipStaticFile(name); err != nil {
+			s.logger.Warn("failed to precompress static file", "file", name, "error", err)
+		}
+	}
+}
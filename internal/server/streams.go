@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdownEvent is sent to event-stream clients when shutdown starts so they
+// can reconnect elsewhere instead of waiting on a dead stream
+const shutdownEvent = "event: shutdown\ndata: server shutting down\n\n"
+
+// errStreamClosed is returned to handlers writing to a stream that was closed
+// for shutdown
+var errStreamClosed = errors.New("stream closed for server shutdown")
+
+// trackedStreamKey stores a WebSocket request's trackedStream in its context,
+// so the proxy can register how to close the connection it relays
+type trackedStreamKey struct{}
+
+// streamRegistry tracks the long-lived SSE and WebSocket responses in flight
+type streamRegistry struct {
+	mu     sync.Mutex
+	active map[*trackedStream]struct{}
+}
+
+func (reg *streamRegistry) add(st *trackedStream) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.active == nil {
+		reg.active = make(map[*trackedStream]struct{})
+	}
+	reg.active[st] = struct{}{}
+}
+
+func (reg *streamRegistry) remove(st *trackedStream) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.active, st)
+}
+
+func (reg *streamRegistry) snapshot() []*trackedStream {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	streams := make([]*trackedStream, 0, len(reg.active))
+	for st := range reg.active {
+		streams = append(streams, st)
+	}
+	return streams
+}
+
+// trackStreams registers event streams and WebSocket upgrades so Shutdown can
+// tell them to go away and close them after Server.StreamShutdownGrace
+func (s *Server) trackStreams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		st := &trackedStream{
+			ResponseWriter: w,
+			controller:     http.NewResponseController(w),
+			cancel:         cancel,
+			registry:       &s.streams,
+		}
+		defer s.streams.remove(st)
+
+		// WebSocket connections are hijacked by the proxy, so they are tracked
+		// up front; the proxy registers a close frame through the context and
+		// the request context is canceled after the grace period
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			ctx = context.WithValue(ctx, trackedStreamKey{}, st)
+			s.streams.add(st)
+		}
+
+		next.ServeHTTP(st, r.WithContext(ctx))
+	})
+}
+
+// trackedStream wraps a response so a shutdown event can be written safely
+// alongside the handler's own writes
+type trackedStream struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	cancel     context.CancelFunc
+	registry   *streamRegistry
+
+	mu          sync.Mutex
+	wroteHeader bool
+	eventStream bool
+	closed      bool
+	goAway      func()
+
+	// tail holds the last bytes of the event stream, and shutdownPending is
+	// set while the shutdown event waits for a partial event to complete
+	tail            []byte
+	shutdownPending bool
+}
+
+// setGoAway registers how a relayed WebSocket sends its close frame
+func (st *trackedStream) setGoAway(goAway func()) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.goAway = goAway
+}
+
+func (st *trackedStream) WriteHeader(code int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.writeHeaderLocked(code)
+}
+
+func (st *trackedStream) writeHeaderLocked(code int) {
+	if !st.wroteHeader && code >= http.StatusOK {
+		st.wroteHeader = true
+		mediaType, _, _ := mime.ParseMediaType(st.Header().Get("Content-Type"))
+		if mediaType == "text/event-stream" {
+			st.eventStream = true
+			st.registry.add(st)
+		}
+	}
+	st.ResponseWriter.WriteHeader(code)
+}
+
+func (st *trackedStream) Write(b []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return 0, errStreamClosed
+	}
+	if !st.wroteHeader {
+		st.writeHeaderLocked(http.StatusOK)
+	}
+
+	n, err := st.ResponseWriter.Write(b)
+	if st.eventStream {
+		st.tail = append(st.tail, b[:n]...)
+		if len(st.tail) > 4 {
+			st.tail = append(st.tail[:0], st.tail[len(st.tail)-4:]...)
+		}
+		if st.shutdownPending && err == nil && st.atEventBoundary() {
+			st.sendShutdownEvent()
+		}
+	}
+	return n, err
+}
+
+// atEventBoundary reports whether the stream written so far ends between
+// events, i.e. after the blank line dispatching the last one
+func (st *trackedStream) atEventBoundary() bool {
+	if len(st.tail) == 0 {
+		return true
+	}
+	for _, end := range []string{"\n\n", "\r\r", "\n\r\n"} {
+		if bytes.HasSuffix(st.tail, []byte(end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendShutdownEvent writes the shutdown event and rejects any further writes
+// from the handler
+func (st *trackedStream) sendShutdownEvent() {
+	st.closed = true
+	st.ResponseWriter.Write([]byte(shutdownEvent))
+	if f, ok := st.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Flush forwards to the underlying writer so events reach the client
+func (st *trackedStream) Flush() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return
+	}
+	if !st.wroteHeader {
+		st.writeHeaderLocked(http.StatusOK)
+	}
+	if f, ok := st.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (st *trackedStream) Unwrap() http.ResponseWriter {
+	return st.ResponseWriter
+}
+
+// announceShutdown sends the shutdown event to an event stream, or a 1001
+// close frame to a WebSocket. An event the handler has only partly written is
+// completed first, so the shutdown event is never spliced into it.
+func (st *trackedStream) announceShutdown() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return
+	}
+
+	switch {
+	case st.eventStream && !st.atEventBoundary():
+		st.shutdownPending = true
+	case st.eventStream:
+		st.sendShutdownEvent()
+	case st.goAway != nil:
+		st.closed = true
+		st.goAway()
+	}
+}
+
+// forceClose cancels the handler's context and expires the connection
+// deadlines so blocked reads and writes return
+func (st *trackedStream) forceClose() {
+	st.cancel()
+	st.controller.SetReadDeadline(time.Now())
+	st.controller.SetWriteDeadline(time.Now())
+}
+
+// closeStreams announces shutdown to every tracked stream and force-closes
+// whatever is still open once the grace period has passed
+func (s *Server) closeStreams(grace time.Duration) {
+	streams := s.streams.snapshot()
+	if len(streams) == 0 {
+		return
+	}
+
+	s.logger.Info("closing long-lived streams", "streams", len(streams), "grace", grace)
+	for _, st := range streams {
+		st.announceShutdown()
+	}
+
+	time.AfterFunc(grace, func() {
+		for _, st := range s.streams.snapshot() {
+			st.forceClose()
+		}
+	})
+}
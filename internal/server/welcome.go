@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+)
+
+// welcomePage is served at / by first-run deployments with no content yet
+const welcomePage = `<!DOCTYPE html>
+<html>
+<head><title>Welcome to FeatherJet</title></head>
+<body>
+<h1>FeatherJet is running</h1>
+<p>The static directory is empty. Add an index.html to replace this page.</p>
+<ul>
+<li><a href="/api/status">Server status</a></li>
+<li><a href="https://github.com/featherjet/featherjet#readme">Documentation</a></li>
+</ul>
+</body>
+</html>
+`
+
+// serveWelcomePage answers / with the built-in welcome page when
+// Static.ShowWelcomePage is set and the static directory is missing or empty.
+// It reports whether it handled the request.
+func (s *Server) serveWelcomePage(w http.ResponseWriter, r *http.Request) bool {
+	if !s.config.Static.ShowWelcomePage || r.URL.Path != "/" || !dirEmpty(s.config.Static.Directory) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(welcomePage))
+	}
+	return true
+}
+
+// dirEmpty reports whether dir is missing or has no entries
+func dirEmpty(dir string) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	return err == io.EOF
+}
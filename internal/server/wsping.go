@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// wsPingFrame is an unmasked WebSocket ping with an empty payload, as sent
+// from server to client
+var wsPingFrame = []byte{0x89, 0x00}
+
+// wsGoingAwayFrame is an unmasked WebSocket close frame with status 1001
+// (going away), sent to clients when the server shuts down
+var wsGoingAwayFrame = []byte{0x88, 0x02, 0x03, 0xe9}
+
+// wsFrameTracker follows WebSocket frame boundaries in a byte stream, so
+// control frames can be inserted between frames but never inside one
+type wsFrameTracker struct {
+	header    []byte // bytes of a frame header read so far
+	remaining uint64 // payload bytes left in the current frame
+}
+
+func (t *wsFrameTracker) atBoundary() bool {
+	return len(t.header) == 0 && t.remaining == 0
+}
+
+// advance consumes p, which continues the stream seen so far
+func (t *wsFrameTracker) advance(p []byte) {
+	for len(p) > 0 {
+		if t.remaining > 0 {
+			n := uint64(len(p))
+			if n > t.remaining {
+				n = t.remaining
+			}
+			t.remaining -= n
+			p = p[n:]
+			continue
+		}
+
+		t.header = append(t.header, p[0])
+		p = p[1:]
+		if size, ok := wsHeaderSize(t.header); ok && len(t.header) == size {
+			t.remaining = wsPayloadLength(t.header)
+			t.header = t.header[:0]
+		}
+	}
+}
+
+// wsHeaderSize returns the full size of the frame header starting with h, once
+// enough of it is known
+func wsHeaderSize(h []byte) (int, bool) {
+	if len(h) < 2 {
+		return 0, false
+	}
+	size := 2
+	switch h[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, true
+}
+
+// wsPayloadLength decodes the payload length of the complete header h
+func wsPayloadLength(h []byte) uint64 {
+	switch length := h[1] & 0x7f; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(h[2:10])
+	default:
+		return uint64(length)
+	}
+}
+
+// wsPingConn wraps the backend side of a proxied WebSocket. ReverseProxy
+// copies what it reads to the client, so whenever the backend has been
+// silent for interval (0 disables pings), a ping is returned between frames
+// instead. The client's pongs reach the backend, which must ignore
+// unsolicited pongs as RFC 6455 requires. After goAway, the next frame
+// boundary gets a 1001 close frame and the stream ends, which makes
+// ReverseProxy close both connections.
+type wsPingConn struct {
+	io.ReadWriteCloser
+	interval time.Duration
+
+	chunks     chan []byte
+	err        error
+	done       chan struct{}
+	closeOnce  sync.Once
+	goingAway  chan struct{}
+	goAwayOnce sync.Once
+
+	pending   []byte
+	frames    wsFrameTracker
+	timer     *time.Timer
+	closing   bool
+	closeSent bool
+}
+
+func newWSPingConn(backend io.ReadWriteCloser, interval time.Duration) *wsPingConn {
+	c := &wsPingConn{
+		ReadWriteCloser: backend,
+		interval:        interval,
+		chunks:          make(chan []byte),
+		done:            make(chan struct{}),
+		goingAway:       make(chan struct{}),
+	}
+	if interval > 0 {
+		c.timer = time.NewTimer(interval)
+	}
+	go c.pump()
+	return c
+}
+
+// goAway asks for the stream to end with a close frame; it may be called
+// from any goroutine
+func (c *wsPingConn) goAway() {
+	c.goAwayOnce.Do(func() { close(c.goingAway) })
+}
+
+// pump reads the backend so Read can wait for data and the ping timer at once
+func (c *wsPingConn) pump() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := c.ReadWriteCloser.Read(buf)
+		if n > 0 {
+			select {
+			case c.chunks <- append([]byte(nil), buf[:n]...):
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			c.err = err
+			close(c.chunks)
+			return
+		}
+	}
+}
+
+func (c *wsPingConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.closeSent {
+		return 0, io.EOF
+	}
+
+	var tick <-chan time.Time
+	if c.timer != nil {
+		tick = c.timer.C
+	}
+	goingAway := c.goingAway
+
+	for {
+		if c.closing && c.frames.atBoundary() && len(p) >= len(wsGoingAwayFrame) {
+			c.closeSent = true
+			return copy(p, wsGoingAwayFrame), nil
+		}
+
+		select {
+		case chunk, ok := <-c.chunks:
+			if !ok {
+				return 0, c.err
+			}
+			c.frames.advance(chunk)
+			n := copy(p, chunk)
+			c.pending = chunk[n:]
+			c.resetTimer()
+			return n, nil
+		case <-c.done:
+			return 0, net.ErrClosed
+		case <-goingAway:
+			c.closing = true
+			goingAway = nil
+		case <-tick:
+			// A backend stalled mid-frame cannot be interrupted
+			c.timer.Reset(c.interval)
+			if c.frames.atBoundary() && len(p) >= len(wsPingFrame) {
+				return copy(p, wsPingFrame), nil
+			}
+		}
+	}
+}
+
+func (c *wsPingConn) resetTimer() {
+	if c.timer == nil {
+		return
+	}
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	c.timer.Reset(c.interval)
+}
+
+func (c *wsPingConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+	})
+	return c.ReadWriteCloser.Close()
+}
@@ -0,0 +1,94 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	// 1ms..100ms in shuffled order
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration((i*37)%100+1)*time.Millisecond)
+	}
+	first := samples[0]
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := Percentile(samples, tt.p); got != tt.expected {
+			t.Errorf("p%v: expected %v, got %v", tt.p, tt.expected, got)
+		}
+	}
+
+	if got := Percentile(nil, 50); got != 0 {
+		t.Errorf("Expected 0 for no samples, got %v", got)
+	}
+	if got := Percentile([]time.Duration{7 * time.Millisecond}, 99); got != 7*time.Millisecond {
+		t.Errorf("Expected the single sample for any percentile, got %v", got)
+	}
+	if samples[0] != first {
+		t.Error("Expected Percentile to leave the input order untouched")
+	}
+}
+
+func TestRun(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	result, err := Run(context.Background(), Options{URL: target.URL, Concurrency: 4, Duration: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected run to succeed, got %v", err)
+	}
+	if result.Requests == 0 || result.Errors != 0 || result.Statuses[http.StatusOK] != result.Requests {
+		t.Errorf("Expected only successful requests, got %+v", result)
+	}
+	if result.Throughput() <= 0 {
+		t.Errorf("Expected positive throughput, got %v", result.Throughput())
+	}
+
+	if _, err := Run(context.Background(), Options{URL: target.URL, Concurrency: 0, Duration: time.Second}); err == nil {
+		t.Error("Expected zero concurrency to be rejected")
+	}
+}
+
+func TestRunReusesConnections(t *testing.T) {
+	var conns atomic.Int32
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	target.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	target.Start()
+	defer target.Close()
+
+	const concurrency = 8
+	result, err := Run(context.Background(), Options{URL: target.URL, Concurrency: concurrency, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected run to succeed, got %v", err)
+	}
+
+	// Every worker keeps its connection instead of dialing per request
+	if n := int(conns.Load()); n > 2*concurrency {
+		t.Errorf("Expected about %d connections for %d requests, got %d", concurrency, result.Requests, n)
+	}
+}
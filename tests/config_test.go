@@ -1,7 +1,13 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -113,6 +119,7 @@ func TestValidate(t *testing.T) {
 	cfg := &Config{}
 	cfg.Server.Host = "localhost"
 	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
 	cfg.Static.Directory = "./public"
 	cfg.Logging.Level = "info"
 
@@ -145,3 +152,431 @@ func TestValidate(t *testing.T) {
 		t.Error("Expected validation to fail for invalid log level")
 	}
 }
+
+func TestValidateImmutablePattern(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+	cfg.Static.ImmutablePattern = DefaultImmutablePattern
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected default immutable pattern to be valid, got %v", err)
+	}
+
+	cfg.Static.ImmutablePattern = "([a-f"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation to fail for malformed immutable pattern")
+	}
+}
+
+func TestValidateHSTSPreload(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+	cfg.Security.HSTSPreload = true
+	cfg.Security.HSTSIncludeSubDomains = true
+	cfg.Security.HSTSMaxAge = 86400
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation to fail for preload with max-age below one year")
+	}
+
+	cfg.Security.HSTSMaxAge = HSTSPreloadMinAge
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected preload with one year max-age to pass, got %v", err)
+	}
+
+	cfg.Security.HSTSIncludeSubDomains = false
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation to fail for preload without includeSubDomains")
+	}
+}
+
+func TestValidateProxyTarget(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+	cfg.Proxy.Target = "localhost:8080"
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation to fail for proxy target without scheme")
+	}
+
+	cfg.Proxy.Target = "http://localhost:8080"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid proxy target to pass, got %v", err)
+	}
+}
+
+func TestLoadWithIncludes(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+include:
+  - "overrides/local.yaml"
+server:
+  host: "0.0.0.0"
+  port: 9090
+logging:
+  level: "info"
+`
+	override := `
+server:
+  port: 9191
+---
+logging:
+  level: "debug"
+`
+	if err := os.MkdirAll(filepath.Join(dir, "overrides"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(base), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "overrides", "local.yaml"), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error loading config with include, got %v", err)
+	}
+
+	if cfg.Server.Host != "0.0.0.0" {
+		t.Errorf("Expected host from base config, got %s", cfg.Server.Host)
+	}
+	if cfg.Server.Port != 9191 {
+		t.Errorf("Expected port overridden by include, got %d", cfg.Server.Port)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("Expected log level from second include document, got %s", cfg.Logging.Level)
+	}
+}
+
+func TestLoadCyclicInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("include: [\"b.yaml\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("include: [\"a.yaml\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(filepath.Join(dir, "a.yaml")); err == nil {
+		t.Error("Expected cyclic include to fail")
+	}
+}
+
+func TestValidateTimeouts(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = 8080
+	cfg.Server.ReadTimeout = 30 * time.Second
+	cfg.Server.WriteTimeout = 30 * time.Second
+	cfg.Server.IdleTimeout = 120 * time.Second
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid timeouts to pass, got %v", err)
+	}
+	if warnings := cfg.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+
+	// Zero timeouts are allowed but warned about
+	cfg.Server.ReadTimeout = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected zero read timeout to pass, got %v", err)
+	}
+	if warnings := cfg.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0], "read_timeout") {
+		t.Errorf("Expected a read_timeout warning, got %v", warnings)
+	}
+
+	cfg.Server.WriteTimeout = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation to fail for negative write timeout")
+	}
+
+	cfg.Server.WriteTimeout = 30 * time.Second
+	cfg.Server.ShutdownTimeout = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation to fail for zero shutdown timeout")
+	}
+}
+
+func TestLoadErrorTypes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	_, err := Load(write("missing-include.yaml", "include: [\"nowhere.yaml\"]\n"))
+	var notFound *ErrConfigNotFound
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected ErrConfigNotFound for a missing include, got %v", err)
+	}
+	if notFound.Path != filepath.Join(dir, "nowhere.yaml") {
+		t.Errorf("Expected not found path to name the include, got %s", notFound.Path)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Expected ErrConfigNotFound to wrap fs.ErrNotExist")
+	}
+
+	badPath := write("bad.yaml", "server:\n  port: [not a number\n")
+	_, err = Load(badPath)
+	var parseErr *ErrConfigParse
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected ErrConfigParse for malformed YAML, got %v", err)
+	}
+	if parseErr.Path != badPath || parseErr.Err == nil {
+		t.Errorf("Expected parse error with path and cause, got %+v", parseErr)
+	}
+
+	cfg := &Config{}
+	cfg.Server.Port = 0
+	err = cfg.Validate()
+	var invalid *ErrConfigInvalid
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected ErrConfigInvalid from Validate, got %v", err)
+	}
+	if !strings.Contains(invalid.Error(), "invalid port number") {
+		t.Errorf("Expected cause to be preserved, got %q", invalid.Error())
+	}
+}
+
+func TestValidateIPv6Host(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+
+	for host, valid := range map[string]bool{
+		"localhost":    true,
+		"0.0.0.0":      true,
+		"::1":          true,
+		"[::1]":        true,
+		"fe80::1%eth0": true,
+		"[::1":         false,
+		"::1]":         false,
+		"[localhost]":  false,
+		"local:host":   false,
+	} {
+		cfg.Server.Host = host
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("Host %q: expected valid=%v, got %v", host, valid, err)
+		}
+	}
+}
+
+func TestValidateMutuallyExclusive(t *testing.T) {
+	newConfig := func() *Config {
+		cfg := &Config{}
+		cfg.Server.Port = 8080
+		cfg.Server.ShutdownTimeout = 30 * time.Second
+		cfg.Static.Directory = "./public"
+		cfg.Logging.Level = "info"
+		return cfg
+	}
+
+	tests := []struct {
+		name   string
+		apply  func(*Config)
+		fields []string
+	}{
+		{"autocert with static certificate", func(c *Config) {
+			c.TLS.CertFile, c.TLS.KeyFile = "cert.pem", "key.pem"
+			c.TLS.AutoCert.Domains = []string{"example.com"}
+			c.TLS.AutoCert.CacheDir = "certs"
+		}, []string{"tls.cert_file", "tls.autocert.domains"}},
+		{"insecure proxy with CA", func(c *Config) {
+			c.Proxy.TLS.InsecureSkipVerify = true
+			c.Proxy.TLS.CACertFile = "ca.pem"
+		}, []string{"proxy.tls.insecure_skip_verify", "proxy.tls.ca_cert_file"}},
+		{"unix timestamps with time zone", func(c *Config) {
+			c.Logging.TimeFormat = "unix"
+			c.Logging.TimeZone = "UTC"
+		}, []string{"logging.time_format", "logging.time_zone"}},
+		{"per-IP connection limit behind PROXY protocol", func(c *Config) {
+			c.Server.MaxConnectionsPerIP = 10
+			c.Server.ProxyProtocol = true
+		}, []string{"server.max_connections_per_ip", "server.proxy_protocol"}},
+	}
+
+	for _, tt := range tests {
+		cfg := newConfig()
+		tt.apply(cfg)
+
+		err := cfg.Validate()
+		if err == nil {
+			t.Errorf("%s: expected a validation error", tt.name)
+			continue
+		}
+		for _, field := range tt.fields {
+			if !strings.Contains(err.Error(), field) {
+				t.Errorf("%s: expected error to name %s, got %q", tt.name, field, err)
+			}
+		}
+	}
+}
+
+func TestLoadSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "api-token"), []byte("s3cret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FEATHERJET_TEST_KEY_FILE", "/run/secrets/tls.key")
+
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `tls:
+  cert_file: /etc/tls/cert.pem
+  key_file: ${env:FEATHERJET_TEST_KEY_FILE}
+proxy:
+  set_response_headers:
+    X-Api-Key: "${file:api-token}"
+    X-Literal: "plain $value"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Expected secret references to resolve, got %v", err)
+	}
+	if cfg.TLS.KeyFile != "/run/secrets/tls.key" {
+		t.Errorf("Expected key_file from the environment, got %q", cfg.TLS.KeyFile)
+	}
+	if got := cfg.Proxy.SetResponseHeaders["X-Api-Key"]; got != "s3cret-token" {
+		t.Errorf("Expected header value from the secret file without its newline, got %q", got)
+	}
+	if got := cfg.Proxy.SetResponseHeaders["X-Literal"]; got != "plain $value" {
+		t.Errorf("Expected values without references to be left alone, got %q", got)
+	}
+
+	for name, ref := range map[string]string{
+		"missing-env.yaml":  "${env:FEATHERJET_TEST_UNSET_VARIABLE}",
+		"missing-file.yaml": "${file:no-such-secret}",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte("tls:\n  key_file: "+ref+"\n"), 0644)
+
+		_, err := Load(path)
+		var parseErr *ErrConfigParse
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: expected ErrConfigParse for an unresolvable reference, got %v", name, err)
+		}
+	}
+}
+
+func TestValidateCipherSuites(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Host = "localhost"
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+
+	cfg.TLS.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_NOT_A_REAL_SUITE"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "TLS_RSA_WITH_NOT_A_REAL_SUITE") {
+		t.Errorf("Expected unknown cipher suite to fail validation, got %v", err)
+	}
+
+	// Suites crypto/tls lists as insecure are refused as well
+	cfg.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected insecure cipher suite to fail validation")
+	}
+
+	// HTTP/2 cannot start without an AES-128-GCM ECDHE suite
+	cfg.TLS.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "HTTP/2") {
+		t.Errorf("Expected a list without an HTTP/2 suite to fail validation, got %v", err)
+	}
+
+	cfg.TLS.CipherSuites = append(cfg.TLS.CipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid cipher suites to pass, got %v", err)
+	}
+	if warnings := cfg.Warnings(); !slices.ContainsFunc(warnings, func(w string) bool { return strings.Contains(w, "TLS 1.3") }) {
+		t.Errorf("Expected a warning that cipher_suites does not cover TLS 1.3, got %v", warnings)
+	}
+
+	// The deprecated preference is accepted with a warning
+	cfg.TLS.PreferServerCipherSuites = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected prefer_server_cipher_suites to stay valid, got %v", err)
+	}
+	if warnings := cfg.Warnings(); !slices.ContainsFunc(warnings, func(w string) bool { return strings.Contains(w, "prefer_server_cipher_suites is deprecated") }) {
+		t.Errorf("Expected a deprecation warning for prefer_server_cipher_suites, got %v", warnings)
+	}
+}
+
+func TestValidateHTTP3(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Host = "localhost"
+	cfg.Server.Port = 8443
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = "./public"
+	cfg.Logging.Level = "info"
+
+	cfg.Server.EnableHTTP3 = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "enable_http3 requires tls") {
+		t.Errorf("Expected HTTP/3 without TLS to fail validation, got %v", err)
+	}
+
+	cfg.TLS.CertFile = "cert.pem"
+	cfg.TLS.KeyFile = "key.pem"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected HTTP/3 with TLS to pass, got %v", err)
+	}
+}
+
+func TestWarningsForRiskyConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bundle.js"), bytes.Repeat([]byte("var x = 1;\n"), 40<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	cfg.Server.Host = "0.0.0.0"
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Static.Directory = dir
+	cfg.Logging.Level = "info"
+	cfg.Proxy.Target = "http://localhost:9000"
+
+	warnings, err := cfg.ValidateWithWarnings()
+	if err != nil {
+		t.Fatalf("Expected a risky config to stay valid, got %v", err)
+	}
+
+	for _, expected := range []string{
+		"every network interface",
+		"read_timeout is 0",
+		"write_timeout is 0",
+		"idle_timeout is 0",
+		"max_request_timeout is 0",
+		"bundle.js",
+	} {
+		if !slices.ContainsFunc(warnings, func(w string) bool { return strings.Contains(w, expected) }) {
+			t.Errorf("Expected a warning mentioning %q, got %v", expected, warnings)
+		}
+	}
+
+	// Errors still come first, without warnings
+	cfg.Server.Port = 0
+	if warnings, err := cfg.ValidateWithWarnings(); err == nil || warnings != nil {
+		t.Errorf("Expected only an error for an invalid config, got %v, %v", warnings, err)
+	}
+}
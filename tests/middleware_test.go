@@ -0,0 +1,543 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// okHandler is a terminal handler that always responds 200 OK
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestHSTS(t *testing.T) {
+	handler := HSTS(31536000, true, true)(okHandler)
+
+	// Plain HTTP requests must not receive the header
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if hsts := rr.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("Expected no HSTS header over HTTP, got %q", hsts)
+	}
+
+	// TLS requests get the full preload directive
+	req = httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	expected := "max-age=31536000; includeSubDomains; preload"
+	if hsts := rr.Header().Get("Strict-Transport-Security"); hsts != expected {
+		t.Errorf("Expected HSTS header %q, got %q", expected, hsts)
+	}
+}
+
+func TestAccessLogCombinedFormat(t *testing.T) {
+	format, err := ParseAccessLogFormat("combined")
+	if err != nil {
+		t.Fatalf("Expected combined preset to parse, got %v", err)
+	}
+
+	var out bytes.Buffer
+	handler := AccessLog(format, &out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest("POST", "/api/tasks?id=1", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("User-Agent", "test-agent/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	pattern := regexp.MustCompile(`^192\.0\.2\.10 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /api/tasks\?id=1 HTTP/1\.1" 201 5 "http://example\.com/" "test-agent/1\.0"\n$`)
+	if !pattern.MatchString(out.String()) {
+		t.Errorf("Combined log line has unexpected layout: %q", out.String())
+	}
+}
+
+func TestAccessLogCustomFormat(t *testing.T) {
+	format, err := ParseAccessLogFormat("%{method} %{path} %{status} %{bytes}")
+	if err != nil {
+		t.Fatalf("Expected custom format to parse, got %v", err)
+	}
+
+	var out bytes.Buffer
+	AccessLog(format, &out)(okHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+
+	if out.String() != "GET /x 200 0\n" {
+		t.Errorf("Unexpected custom log line: %q", out.String())
+	}
+
+	if _, err := ParseAccessLogFormat("%{nope}"); err == nil {
+		t.Error("Expected unknown token to be rejected")
+	}
+}
+
+func TestAccessLogUpstreamDuration(t *testing.T) {
+	format, err := ParseAccessLogFormat("%{path} %{upstream_duration}")
+	if err != nil {
+		t.Fatalf("Expected upstream token to parse, got %v", err)
+	}
+
+	var out bytes.Buffer
+	handler := TrackUpstream(AccessLog(format, &out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tasks" {
+			RecordUpstream(r.Context(), 42*time.Millisecond)
+		}
+	})))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/tasks", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html", nil))
+
+	if out.String() != "/api/tasks 42ms\n/index.html -\n" {
+		t.Errorf("Unexpected upstream log lines: %q", out.String())
+	}
+}
+
+func TestCompressBypass(t *testing.T) {
+	body := strings.Repeat("body { color: red; }\n", 100)
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(body))
+	}))
+
+	// Regular request is compressed
+	req := httptest.NewRequest("GET", "/styles.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Expected gzip Content-Encoding, got %q", ce)
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected valid gzip body: %v", err)
+	}
+	if decoded, _ := io.ReadAll(gz); string(decoded) != body {
+		t.Error("Decompressed body does not match original")
+	}
+
+	// Query parameter and header both bypass compression
+	for _, bypass := range []func(*http.Request){
+		func(r *http.Request) { r.URL.RawQuery = "nocompress=1" },
+		func(r *http.Request) { r.Header.Set(NoCompressionHeader, "1") },
+	} {
+		req := httptest.NewRequest("GET", "/styles.css", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		bypass(req)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("Expected no Content-Encoding when bypassed, got %q", ce)
+		}
+		if rr.Body.String() != body {
+			t.Error("Expected raw body when compression is bypassed")
+		}
+		if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("Expected Vary: Accept-Encoding, got %q", vary)
+		}
+	}
+}
+
+func TestBlockUserAgents(t *testing.T) {
+	patterns, err := CompileUserAgentPatterns([]string{"BadBot", `/^curl\/[0-9.]+$/`})
+	if err != nil {
+		t.Fatalf("Failed to compile patterns: %v", err)
+	}
+	handler := BlockUserAgents(patterns)(okHandler)
+
+	tests := []struct {
+		userAgent string
+		expected  int
+	}{
+		{"Mozilla/5.0 (compatible; badbot/2.1)", http.StatusForbidden},
+		{"curl/8.4.0", http.StatusForbidden},
+		{"Mozilla/5.0 (X11; Linux x86_64) curl/8.4.0 wrapper", http.StatusOK},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64)", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("User-Agent", tt.userAgent)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("User-Agent %q: expected %d, got %d", tt.userAgent, tt.expected, rr.Code)
+		}
+	}
+
+	if _, err := CompileUserAgentPatterns([]string{"/[unclosed/"}); err == nil {
+		t.Error("Expected an invalid regex pattern to be rejected")
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	handler := PathFilter(nil, []string{"/private/**", "/*.bak"})(okHandler)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/private/keys.txt", http.StatusForbidden},
+		{"/private", http.StatusForbidden},
+		{"/public/../private/keys.txt", http.StatusForbidden},
+		{"/site.bak", http.StatusForbidden},
+		{"/index.html", http.StatusOK},
+		{"/privateer.html", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = tt.path
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.expected, rr.Code)
+		}
+	}
+
+	// With an allowlist everything else is denied
+	handler = PathFilter([]string{"/api/**", "/"}, nil)(okHandler)
+	for path, expected := range map[string]int{"/api/hello": http.StatusOK, "/": http.StatusOK, "/admin": http.StatusForbidden} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != expected {
+			t.Errorf("allowlist %s: expected %d, got %d", path, expected, rr.Code)
+		}
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	small := `{"ok":true}`
+	large := strings.Repeat(`{"id":1,"title":"task"},`, 100)
+
+	tests := []struct {
+		name       string
+		body       string
+		flush      bool
+		compressed bool
+	}{
+		{"small", small, false, false},
+		{"large", large, false, true},
+		{"flushed before threshold", small, true, false},
+	}
+
+	for _, tt := range tests {
+		handler := CompressMinSize(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(tt.body))
+			if tt.flush {
+				w.(http.Flusher).Flush()
+			}
+		}))
+
+		req := httptest.NewRequest("GET", "/api/tasks", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		encoded := rr.Header().Get("Content-Encoding") == "gzip"
+		if encoded != tt.compressed {
+			t.Errorf("%s: expected compressed=%v, got Content-Encoding %q", tt.name, tt.compressed, rr.Header().Get("Content-Encoding"))
+			continue
+		}
+
+		body := rr.Body.String()
+		if encoded {
+			gz, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatalf("%s: expected valid gzip body: %v", tt.name, err)
+			}
+			decoded, _ := io.ReadAll(gz)
+			body = string(decoded)
+		}
+		if body != tt.body {
+			t.Errorf("%s: body does not match original", tt.name)
+		}
+	}
+}
+
+func TestAccessLogTimeFormatter(t *testing.T) {
+	format, err := ParseAccessLogFormat("[%{time}] %{status}")
+	if err != nil {
+		t.Fatalf("Expected format to parse, got %v", err)
+	}
+	format.SetTimeFormatter(func(ts time.Time) string {
+		return ts.UTC().Format(time.RFC3339)
+	})
+
+	var out bytes.Buffer
+	AccessLog(format, &out)(okHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	pattern := regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z\] 200\n$`)
+	if !pattern.MatchString(out.String()) {
+		t.Errorf("Expected an RFC3339 UTC access log timestamp, got %q", out.String())
+	}
+}
+
+func TestServerHeader(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream/1.0")
+		w.Write([]byte("ok"))
+	})
+
+	rr := httptest.NewRecorder()
+	ServerHeader("featherjet")(upstream).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if server := rr.Header().Get("Server"); server != "featherjet" {
+		t.Errorf("Expected Server: featherjet, got %q", server)
+	}
+
+	rr = httptest.NewRecorder()
+	ServerHeader("")(upstream).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if _, ok := rr.Header()["Server"]; ok {
+		t.Errorf("Expected empty value to remove the Server header, got %q", rr.Header().Get("Server"))
+	}
+}
+
+func TestAccessLogQueryFilter(t *testing.T) {
+	format, err := ParseAccessLogFormat("%{uri}")
+	if err != nil {
+		t.Fatalf("Expected format to parse, got %v", err)
+	}
+
+	tests := []struct {
+		filter   QueryFilter
+		expected string
+	}{
+		{QueryFilter{}, "/login?user=bob&token=s3cret&next=%2Fhome"},
+		{QueryFilter{Redact: []string{"token", "user"}}, "/login?user=***&token=***&next=%2Fhome"},
+		{QueryFilter{Omit: true, Redact: []string{"token"}}, "/login"},
+	}
+
+	for _, tt := range tests {
+		format.SetQueryFilter(tt.filter)
+
+		var out bytes.Buffer
+		req := httptest.NewRequest("GET", "/login?user=bob&token=s3cret&next=%2Fhome", nil)
+		AccessLog(format, &out)(okHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		if line := strings.TrimSuffix(out.String(), "\n"); line != tt.expected {
+			t.Errorf("Filter %+v: expected %q, got %q", tt.filter, tt.expected, line)
+		}
+	}
+}
+
+func TestAdaptiveCompress(t *testing.T) {
+	body := strings.Repeat("body { color: red; }\n", 100)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+
+	handler := AdaptiveCompress(0, 2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(body))
+	}))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if ce := request("/styles.css").Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Expected gzip when idle, got Content-Encoding %q", ce)
+	}
+
+	// Hold two requests in flight so the next one crosses the threshold
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			request("/slow")
+			done <- struct{}{}
+		}()
+	}
+	<-started
+	<-started
+
+	rr := request("/styles.css")
+	close(release)
+	<-done
+	<-done
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("Expected compression to be skipped under load, got Content-Encoding %q", ce)
+	}
+	if rr.Body.String() != body {
+		t.Error("Expected the raw body when compression is skipped")
+	}
+	if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", vary)
+	}
+
+	if ce := request("/styles.css").Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Expected gzip once load drops, got Content-Encoding %q", ce)
+	}
+}
+
+func TestStatusFilter(t *testing.T) {
+	filter, err := ParseStatusFilter([]string{">=400"})
+	if err != nil {
+		t.Fatalf("Expected filter to parse, got %v", err)
+	}
+
+	var out bytes.Buffer
+	handler := RequestLogger(slog.New(slog.NewTextHandler(&out, nil)), QueryFilter{}, filter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}))
+
+	for _, status := range []int{200, 204, 301, 404, 503} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/?status=%d", status), nil))
+	}
+
+	logged := regexp.MustCompile(` status=(\d+)`).FindAllStringSubmatch(out.String(), -1)
+	if len(logged) != 2 || logged[0][1] != "404" || logged[1][1] != "503" {
+		t.Errorf("Expected only 404 and 503 to be logged, got %q", out.String())
+	}
+
+	filter, err = ParseStatusFilter([]string{"404", "5xx", "300-302", "<200"})
+	if err != nil {
+		t.Fatalf("Expected filter to parse, got %v", err)
+	}
+	for status, expected := range map[int]bool{404: true, 403: false, 500: true, 599: true, 301: true, 304: false, 101: true, 200: false} {
+		if filter.Match(status) != expected {
+			t.Errorf("Status %d: expected match=%v", status, expected)
+		}
+	}
+
+	for _, invalid := range []string{"abc", ">=600", "500-400", "9xx"} {
+		if _, err := ParseStatusFilter([]string{invalid}); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestContextHeaders(t *testing.T) {
+	var tenant string
+	handler := ContextHeaders([]ContextHeader{
+		{Name: "X-Tenant-ID", Pattern: regexp.MustCompile(`^[a-z0-9-]{1,32}$`)},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = ContextHeaderValue(r.Context(), "x-tenant-id")
+	}))
+
+	tests := []struct {
+		values   []string
+		expected int
+		tenant   string
+	}{
+		{[]string{"acme-42"}, http.StatusOK, "acme-42"},
+		{nil, http.StatusOK, ""},
+		{[]string{"Acme; DROP"}, http.StatusBadRequest, ""},
+		{[]string{"acme", "globex"}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		tenant = ""
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, value := range tt.values {
+			req.Header.Add("X-Tenant-ID", value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("X-Tenant-ID %q: expected %d, got %d", tt.values, tt.expected, rr.Code)
+		}
+		if tenant != tt.tenant {
+			t.Errorf("X-Tenant-ID %q: expected context value %q, got %q", tt.values, tt.tenant, tenant)
+		}
+	}
+}
+
+func TestTimeoutDropsLateWrites(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	handler := Timeout(20*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "1")
+		<-r.Context().Done()
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %d", rr.Code)
+	}
+	if err := <-lateWrite; err != http.ErrHandlerTimeout {
+		t.Errorf("Expected http.ErrHandlerTimeout for a write after the timeout, got %v", err)
+	}
+	if rr.Header().Get("X-Handler") != "" || strings.Contains(rr.Body.String(), "too late") {
+		t.Errorf("Expected the timed out handler's response to be discarded, got %v %q", rr.Header(), rr.Body.String())
+	}
+
+	// Responses that already started stream on until the handler returns
+	streaming := Timeout(20*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+	}))
+	rr = httptest.NewRecorder()
+	streaming.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "partial" {
+		t.Errorf("Expected the started response to be kept, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTimeoutSkipsEventStreams(t *testing.T) {
+	handler := Timeout(20*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		for i := 0; i < 4; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "data: 3") {
+		t.Errorf("Expected the event stream to outlive the timeout, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// WebSocket upgrades are exempt too
+	var canceled bool
+	handler = Timeout(20*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		canceled = r.Context().Err() != nil
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if canceled || rr.Code != http.StatusSwitchingProtocols {
+		t.Errorf("Expected the upgrade to run without a timeout, got %d (canceled %v)", rr.Code, canceled)
+	}
+}
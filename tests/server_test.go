@@ -1,19 +1,48 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/featherjet/featherjet/internal/config"
+	"github.com/featherjet/featherjet/internal/middleware"
+	"github.com/quic-go/quic-go/http3"
+	"gopkg.in/yaml.v3"
 )
 
 func TestNew(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Server.Host = "localhost"
 	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
 	cfg.Server.ReadTimeout = 30 * time.Second
 	cfg.Server.WriteTimeout = 30 * time.Second
 	cfg.Server.IdleTimeout = 120 * time.Second
@@ -23,9 +52,9 @@ func TestNew(t *testing.T) {
 	cfg.Logging.EnableRequestLogging = true
 	cfg.Middleware.EnableCORS = true
 
-	server := New(cfg)
-	if server == nil {
-		t.Fatal("Expected server to be created, got nil")
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Expected server to be created, got %v", err)
 	}
 
 	if server.config != cfg {
@@ -37,6 +66,7 @@ func TestHandleHello(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Server.Host = "localhost"
 	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
 	cfg.Server.ReadTimeout = 30 * time.Second
 	cfg.Server.WriteTimeout = 30 * time.Second
 	cfg.Server.IdleTimeout = 120 * time.Second
@@ -45,7 +75,7 @@ func TestHandleHello(t *testing.T) {
 	cfg.Logging.EnableRequestLogging = false // Disable for testing
 	cfg.Middleware.EnableCORS = false
 
-	server := New(cfg)
+	server := newTestServer(t, cfg)
 
 	req, err := http.NewRequest("GET", "/api/hello", nil)
 	if err != nil {
@@ -83,6 +113,7 @@ func TestHandleStatus(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Server.Host = "localhost"
 	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
 	cfg.Server.ReadTimeout = 30 * time.Second
 	cfg.Server.WriteTimeout = 30 * time.Second
 	cfg.Server.IdleTimeout = 120 * time.Second
@@ -91,7 +122,7 @@ func TestHandleStatus(t *testing.T) {
 	cfg.Logging.EnableRequestLogging = false
 	cfg.Middleware.EnableCORS = false
 
-	server := New(cfg)
+	server := newTestServer(t, cfg)
 
 	req, err := http.NewRequest("GET", "/api/status", nil)
 	if err != nil {
@@ -124,6 +155,7 @@ func TestHandleInfo(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Server.Host = "localhost"
 	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
 	cfg.Server.ReadTimeout = 30 * time.Second
 	cfg.Server.WriteTimeout = 30 * time.Second
 	cfg.Server.IdleTimeout = 120 * time.Second
@@ -132,7 +164,7 @@ func TestHandleInfo(t *testing.T) {
 	cfg.Logging.EnableRequestLogging = false
 	cfg.Middleware.EnableCORS = true
 
-	server := New(cfg)
+	server := newTestServer(t, cfg)
 
 	req, err := http.NewRequest("GET", "/api/info", nil)
 	if err != nil {
@@ -175,3 +207,3909 @@ func TestHandleInfo(t *testing.T) {
 		}
 	}
 }
+
+// newTestServer creates a server and fails the test if the config is rejected
+func newTestServer(t *testing.T, cfg *config.Config, opts ...Option) *Server {
+	t.Helper()
+	server, err := New(cfg, opts...)
+	if err != nil {
+		t.Fatalf("Expected server to be created, got %v", err)
+	}
+	return server
+}
+
+// newTestConfig returns a valid configuration serving files from staticDir
+func newTestConfig(staticDir string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Server.Host = "localhost"
+	cfg.Server.Port = 8080
+	cfg.Server.ShutdownTimeout = 30 * time.Second
+	cfg.Server.ReadTimeout = 30 * time.Second
+	cfg.Server.WriteTimeout = 30 * time.Second
+	cfg.Server.IdleTimeout = 120 * time.Second
+	cfg.Static.Directory = staticDir
+	cfg.Static.CacheMaxAge = "3600"
+	cfg.Logging.Level = "info"
+	cfg.Logging.EnableRequestLogging = false
+	cfg.Middleware.EnableCORS = false
+	return cfg
+}
+
+// writeStaticFiles creates the given files (path -> content) under dir
+func writeStaticFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStaticCachePolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"app.4f3a2b.js": "console.log('fingerprinted')",
+		"app.js":        "console.log('plain')",
+		"index.html":    "<h1>home</h1>",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.ImmutablePattern = config.DefaultImmutablePattern
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/app.4f3a2b.js", "public, max-age=31536000, immutable"},
+		{"/app.js", "max-age=3600"},
+		{"/index.html", "no-cache"},
+		{"/", "no-cache"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rr, req)
+
+		if cc := rr.Header().Get("Cache-Control"); cc != tt.expected {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tt.path, tt.expected, cc)
+		}
+	}
+}
+
+func TestListenOrInherit(t *testing.T) {
+	// Without the environment variable a fresh listener is opened
+	ln, err := listenOrInherit(listenerFDEnv, "127.0.0.1:0", net.ListenConfig{})
+	if err != nil {
+		t.Fatalf("Expected new listener, got %v", err)
+	}
+	defer ln.Close()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("listener inheritance is not supported on Windows")
+	}
+
+	// listenOrInherit takes ownership of (and closes) the duplicated descriptor
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate the parent passing the socket down by file descriptor
+	t.Setenv(listenerFDEnv, strconv.Itoa(int(file.Fd())))
+
+	inherited, err := listenOrInherit(listenerFDEnv, "127.0.0.1:0", net.ListenConfig{})
+	if err != nil {
+		t.Fatalf("Expected inherited listener, got %v", err)
+	}
+	defer inherited.Close()
+
+	if inherited.Addr().String() != ln.Addr().String() {
+		t.Errorf("Expected inherited address %s, got %s", ln.Addr(), inherited.Addr())
+	}
+
+	if os.Getenv(listenerFDEnv) != "" {
+		t.Error("Expected listener environment variable to be cleared after inheriting")
+	}
+}
+
+func TestRestartHandsOffAdminListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listener inheritance is not supported on Windows")
+	}
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.AdminAddr = "127.0.0.1:0"
+	parent := newTestServer(t, cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	parent.listener = ln
+	go parent.httpServer.Serve(ln)
+	if err := parent.startAdmin(); err != nil {
+		t.Fatalf("Failed to start admin listener: %v", err)
+	}
+
+	// Hand the sockets over as Restart does, using this process's descriptors
+	files, err := parent.handoffFiles()
+	if err != nil {
+		t.Fatalf("Failed to prepare handoff: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected the main and admin listeners to be handed off, got %d", len(files))
+	}
+	for _, f := range files {
+		t.Setenv(f.env, strconv.Itoa(int(f.file.Fd())))
+	}
+
+	// The child binds the same fixed admin address the parent still holds
+	adminAddr := parent.adminListener.Addr().String()
+	childCfg := newTestConfig(t.TempDir())
+	childCfg.Server.AdminAddr = adminAddr
+	child := newTestServer(t, childCfg)
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- child.Start() }()
+
+	// Once the parent is gone, the admin address keeps answering
+	parent.Shutdown(context.Background())
+
+	client := &http.Client{Timeout: time.Second}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		select {
+		case err := <-startErr:
+			t.Fatalf("Expected the child to start on the inherited listeners, got %v", err)
+		default:
+		}
+		if resp, err = client.Get("http://" + adminAddr + "/api/readyz"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expected the child's admin listener to answer: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected child readiness 200, got %d", resp.StatusCode)
+	}
+
+	child.Shutdown(context.Background())
+}
+
+// serve runs req through the server's full middleware chain
+func serve(s *Server, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestProxyResponseHeaderFiltering(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "Express")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.StripResponseHeaders = []string{"X-Powered-By"}
+	cfg.Proxy.SetResponseHeaders = map[string]string{"Cache-Control": "no-store"}
+	server := newTestServer(t, cfg)
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if v := rr.Header().Get("X-Powered-By"); v != "" {
+		t.Errorf("Expected X-Powered-By to be stripped, got %q", v)
+	}
+	if v := rr.Header().Get("Cache-Control"); v != "no-store" {
+		t.Errorf("Expected Cache-Control 'no-store', got %q", v)
+	}
+}
+
+func TestStartupEventJSON(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Logging.Format = "json"
+
+	var buf bytes.Buffer
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	server.logStartup("127.0.0.1:9999")
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Expected startup log to be a JSON object, got %q: %v", buf.String(), err)
+	}
+
+	if addr, _ := event["listen_address"].(string); addr != "127.0.0.1:9999" {
+		t.Errorf("Expected listen_address '127.0.0.1:9999', got %v", event["listen_address"])
+	}
+	if version, _ := event["version"].(string); version != Version {
+		t.Errorf("Expected version %s, got %v", Version, event["version"])
+	}
+
+	// The middleware list is the chain that was actually built
+	middleware, _ := event["middleware"].([]interface{})
+	if len(middleware) != len(server.middlewareNames) {
+		t.Fatalf("Expected middleware %v, got %v", server.middlewareNames, event["middleware"])
+	}
+	for i, name := range server.middlewareNames {
+		if middleware[i] != name {
+			t.Errorf("Expected middleware %v, got %v", server.middlewareNames, middleware)
+			break
+		}
+	}
+}
+
+func TestAPINotFoundNegotiation(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"404.html": "<h1>custom missing page</h1>"})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.NotFoundPage = "404.html"
+	server := newTestServer(t, cfg)
+
+	// JSON clients get a JSON body
+	req := httptest.NewRequest("GET", "/api/unknown", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := serve(server, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %q", ct)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Errorf("Expected JSON body, got %q", rr.Body.String())
+	}
+
+	// Browsers get the configured HTML page
+	req = httptest.NewRequest("GET", "/api/unknown", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	rr = serve(server, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	if rr.Body.String() != "<h1>custom missing page</h1>" {
+		t.Errorf("Expected custom 404 page, got %q", rr.Body.String())
+	}
+}
+
+func TestDrainFlipsReadiness(t *testing.T) {
+	server := newTestServer(t, newTestConfig(t.TempDir()))
+
+	if rr := serve(server, httptest.NewRequest("GET", "/api/readyz", nil)); rr.Code != http.StatusOK {
+		t.Fatalf("Expected readiness 200 before drain, got %d", rr.Code)
+	}
+
+	// Remote clients may not trigger a drain
+	req := httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "203.0.113.5:40000"
+	if rr := serve(server, req); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for remote drain request, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	if rr := serve(server, req); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 from drain endpoint, got %d", rr.Code)
+	}
+
+	if !server.Draining() {
+		t.Error("Expected server to report draining")
+	}
+	if rr := serve(server, httptest.NewRequest("GET", "/api/readyz", nil)); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness 503 after drain, got %d", rr.Code)
+	}
+
+	// Normal traffic is still served while draining
+	if rr := serve(server, httptest.NewRequest("GET", "/api/hello", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected /api/hello to keep working while draining, got %d", rr.Code)
+	}
+}
+
+func TestDrainThroughTrustedProxy(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.TrustedProxies = []string{"127.0.0.1"}
+	server := newTestServer(t, cfg)
+
+	// A reverse proxy on the same host relays a public client
+	req := httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	req.Header.Set("X-Forwarded-For", "127.0.0.1, 203.0.113.5")
+	if rr := serve(server, req); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a drain request relayed from a remote client, got %d", rr.Code)
+	}
+	if server.Draining() {
+		t.Fatal("Expected a relayed remote client not to start a drain")
+	}
+
+	// Untrusted peers cannot claim to be local
+	req = httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "203.0.113.5:40000"
+	req.Header.Set("X-Forwarded-For", "127.0.0.1")
+	if rr := serve(server, req); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a spoofed X-Forwarded-For, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	req.Header.Set("X-Forwarded-For", "::1")
+	if rr := serve(server, req); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a local client behind the proxy, got %d", rr.Code)
+	}
+}
+
+func TestDrainOnlyOnAdminListener(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.AdminAddr = "127.0.0.1:0"
+	server := newTestServer(t, cfg)
+
+	req := httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	if rr := serve(server, req); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for drain on the public listener, got %d", rr.Code)
+	}
+	if server.Draining() {
+		t.Fatal("Expected the public listener not to start a drain")
+	}
+
+	req = httptest.NewRequest("POST", "/api/drain", nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	rr := httptest.NewRecorder()
+	server.adminServer.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted || !server.Draining() {
+		t.Errorf("Expected the admin listener to start a drain, got %d", rr.Code)
+	}
+}
+
+func TestProxyUpstreamTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"secure":true}`))
+	}))
+	defer backend.Close()
+
+	// Without the backend's CA the proxy refuses the self-signed certificate
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	server := newTestServer(t, cfg)
+
+	if rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil)); rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 without CA bundle, got %d", rr.Code)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.TLS.CACertFile = caFile
+	server = newTestServer(t, cfg)
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with CA bundle, got %d", rr.Code)
+	}
+	if rr.Body.String() != `{"secure":true}` {
+		t.Errorf("Unexpected proxied body: %q", rr.Body.String())
+	}
+}
+
+func TestStatusRequestCounters(t *testing.T) {
+	server := newTestServer(t, newTestConfig(t.TempDir()))
+
+	serve(server, httptest.NewRequest("GET", "/api/hello", nil))
+	serve(server, httptest.NewRequest("GET", "/api/hello", nil))
+	serve(server, httptest.NewRequest("GET", "/missing.txt", nil))
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/status", nil))
+
+	var response struct {
+		Requests struct {
+			Total     int64            `json:"total"`
+			BytesSent int64            `json:"bytes_sent"`
+			InFlight  int64            `json:"in_flight"`
+			Status    map[string]int64 `json:"status"`
+		} `json:"requests"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	counters := response.Requests
+	if counters.Total != 3 {
+		t.Errorf("Expected 3 completed requests, got %d", counters.Total)
+	}
+	if counters.Status["2xx"] != 2 || counters.Status["4xx"] != 1 {
+		t.Errorf("Unexpected status breakdown: %v", counters.Status)
+	}
+	if counters.BytesSent == 0 {
+		t.Error("Expected bytes sent to be counted")
+	}
+	if counters.InFlight != 1 {
+		t.Errorf("Expected only the status request in flight, got %d", counters.InFlight)
+	}
+}
+
+func TestStaticBlocksDotfiles(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		".env":                         "SECRET=1",
+		".git/config":                  "[core]",
+		".well-known/acme-challenge/x": "token",
+		"visible.txt":                  "hello",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.BlockDotfiles = true
+	cfg.Static.DotfileAllowlist = []string{".well-known/"}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/.env", http.StatusNotFound},
+		{"/.git/config", http.StatusNotFound},
+		{"/.well-known/acme-challenge/x", http.StatusOK},
+		{"/visible.txt", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rr := serve(server, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.expected, rr.Code)
+		}
+	}
+}
+
+func TestAutoCertWiring(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.TLS.AutoCert.Domains = []string{"tasks.example.com"}
+	cfg.TLS.AutoCert.CacheDir = t.TempDir()
+	cfg.TLS.AutoCert.HTTPAddr = ":80"
+	server := newTestServer(t, cfg)
+
+	if server.autocertManager == nil {
+		t.Fatal("Expected autocert manager to be configured")
+	}
+	if server.httpServer.TLSConfig == nil || server.httpServer.TLSConfig.GetCertificate == nil {
+		t.Fatal("Expected TLS config to obtain certificates from the autocert manager")
+	}
+	if server.challengeServer == nil || server.challengeServer.Addr != ":80" {
+		t.Fatal("Expected HTTP-01 challenge server on :80")
+	}
+
+	// Challenge paths are handled by the manager rather than redirected
+	req := httptest.NewRequest("GET", "http://tasks.example.com/.well-known/acme-challenge/token", nil)
+	rr := httptest.NewRecorder()
+	server.challengeServer.Handler.ServeHTTP(rr, req)
+	if rr.Code == http.StatusFound {
+		t.Error("Expected ACME challenge path not to be redirected")
+	}
+
+	// Everything else is redirected to HTTPS
+	req = httptest.NewRequest("GET", "http://tasks.example.com/index.html", nil)
+	rr = httptest.NewRecorder()
+	server.challengeServer.Handler.ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "https://tasks.example.com/index.html" {
+		t.Errorf("Expected redirect to HTTPS, got %d %q", rr.Code, loc)
+	}
+}
+
+// slowReader returns one byte per Read, sleeping before each
+type slowReader struct {
+	delay     time.Duration
+	remaining int
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	r.remaining--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.BodyReadTimeout = 50 * time.Millisecond
+	server := newTestServer(t, cfg)
+
+	req := httptest.NewRequest("POST", "/api/tasks", &slowReader{delay: 30 * time.Millisecond, remaining: 10})
+	req.ContentLength = 10
+	if rr := serve(server, req); rr.Code != http.StatusRequestTimeout {
+		t.Errorf("Expected 408 for slow body, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/tasks", bytes.NewReader([]byte(`{"title":"fast"}`)))
+	if rr := serve(server, req); rr.Code != http.StatusCreated {
+		t.Errorf("Expected 201 for fast body, got %d", rr.Code)
+	}
+}
+
+func TestStaticDebugLogsResolvedPath(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"css/site.css": "body {}"})
+
+	// Nothing is logged above debug level
+	var buf bytes.Buffer
+	cfg := newTestConfig(dir)
+	server := newTestServer(t, cfg, WithLogger(newLogger(cfg, &buf)))
+	serve(server, httptest.NewRequest("GET", "/css/site.css", nil))
+	if strings.Contains(buf.String(), "static file resolved") {
+		t.Errorf("Expected no static debug log at info level, got %q", buf.String())
+	}
+
+	cfg = newTestConfig(dir)
+	cfg.Logging.Level = "debug"
+	server = newTestServer(t, cfg, WithLogger(newLogger(cfg, &buf)))
+	serve(server, httptest.NewRequest("GET", "/css/site.css", nil))
+
+	expectedFile := "file=" + filepath.Join(dir, "css", "site.css")
+	if !strings.Contains(buf.String(), expectedFile) || !strings.Contains(buf.String(), `decision="served file"`) {
+		t.Errorf("Expected debug log with %q and served file decision, got %q", expectedFile, buf.String())
+	}
+}
+
+func TestWithLoggerCapturesRequests(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Logging.EnableRequestLogging = true
+	server := newTestServer(t, cfg, WithLogger(logger))
+
+	if server.logger != logger {
+		t.Fatal("Expected injected logger to be used")
+	}
+
+	serve(server, httptest.NewRequest("GET", "/api/hello", nil))
+
+	if !strings.Contains(buf.String(), "path=/api/hello") || !strings.Contains(buf.String(), "status=200") {
+		t.Errorf("Expected request log line in injected logger, got %q", buf.String())
+	}
+}
+
+func TestNewInvalidConfigReturnsError(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.Port = 0
+
+	server, err := New(cfg)
+	if err == nil {
+		t.Fatal("Expected invalid config to return an error")
+	}
+	if server != nil {
+		t.Error("Expected no server for an invalid config")
+	}
+
+	cfg = newTestConfig(t.TempDir())
+	cfg.Logging.AccessLogFormat = "%{unknown}"
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected invalid access log format to return an error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustNew to panic on invalid config")
+		}
+	}()
+	MustNew(&config.Config{})
+}
+
+func TestReusePortSharesListenAddress(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only supported on Linux")
+	}
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.ReusePort = true
+	server := newTestServer(t, cfg)
+
+	first, err := listenOrInherit(listenerFDEnv, "127.0.0.1:0", server.listenConfig())
+	if err != nil {
+		t.Fatalf("Expected first listener, got %v", err)
+	}
+	defer first.Close()
+
+	second, err := listenOrInherit(listenerFDEnv, first.Addr().String(), server.listenConfig())
+	if err != nil {
+		t.Fatalf("Expected second listener to share %s, got %v", first.Addr(), err)
+	}
+	defer second.Close()
+
+	if err := setListenBacklog(second, 1024); err != nil {
+		t.Errorf("Expected backlog to be applied, got %v", err)
+	}
+
+	// Without SO_REUSEPORT the port is taken
+	if ln, err := listenOrInherit(listenerFDEnv, first.Addr().String(), net.ListenConfig{}); err == nil {
+		ln.Close()
+		t.Error("Expected plain listener to fail on a port already in use")
+	}
+}
+
+func TestInlineRobotsAndSecurityTxt(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"robots.txt": "User-agent: *\nAllow: /\n"})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.RobotsTxt = "User-agent: *\nDisallow: /\n"
+	cfg.Static.SecurityTxt = "Contact: mailto:security@example.com\n"
+	server := newTestServer(t, cfg)
+
+	rr := serve(server, httptest.NewRequest("GET", "/robots.txt", nil))
+	if rr.Body.String() != cfg.Static.RobotsTxt {
+		t.Errorf("Expected inline robots.txt, got %q", rr.Body.String())
+	}
+	rr = serve(server, httptest.NewRequest("GET", "/.well-known/security.txt", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != cfg.Static.SecurityTxt {
+		t.Errorf("Expected inline security.txt, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Unset values fall through to the static directory
+	server = newTestServer(t, newTestConfig(dir))
+	rr = serve(server, httptest.NewRequest("GET", "/robots.txt", nil))
+	if rr.Body.String() != "User-agent: *\nAllow: /\n" {
+		t.Errorf("Expected robots.txt from static directory, got %q", rr.Body.String())
+	}
+	rr = serve(server, httptest.NewRequest("GET", "/.well-known/security.txt", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without security.txt, got %d", rr.Code)
+	}
+}
+
+func TestProxyClientDeadline(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte(`[]`))
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.MaxRequestTimeout = 100 * time.Millisecond
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		header   string
+		value    string
+		expected int
+	}{
+		{"X-Request-Deadline", "50ms", http.StatusGatewayTimeout},
+		{"grpc-timeout", "20m", http.StatusGatewayTimeout},
+		// A generous client deadline is clamped to MaxRequestTimeout
+		{"X-Request-Deadline", "10s", http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/tasks", nil)
+		req.Header.Set(tt.header, tt.value)
+		if rr := serve(server, req); rr.Code != tt.expected {
+			t.Errorf("%s: %s: expected %d, got %d", tt.header, tt.value, tt.expected, rr.Code)
+		}
+	}
+
+	cfg.Proxy.MaxRequestTimeout = time.Second
+	server = newTestServer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("grpc-timeout", "1S")
+	if rr := serve(server, req); rr.Code != http.StatusOK {
+		t.Errorf("Expected generous deadline to succeed, got %d", rr.Code)
+	}
+}
+
+func TestProxyStreamsServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+
+		// The second event is only sent once the client has seen the first
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Logging.EnableRequestLogging = true
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	frontend := httptest.NewServer(server.httpServer.Handler)
+	defer frontend.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(frontend.URL + "/api/tasks/events")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatalf("Expected first event before the stream ends: %v", err)
+	}
+	if string(buf) != "data: first\n\n" {
+		t.Errorf("Unexpected first event %q", buf)
+	}
+
+	release <- struct{}{}
+
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read rest of stream: %v", err)
+	}
+	if string(rest) != "data: second\n\n" {
+		t.Errorf("Unexpected second event %q", rest)
+	}
+}
+
+func TestShutdownKeepsAdminListenerUntilDrained(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.AdminAddr = "127.0.0.1:0"
+	server := newTestServer(t, cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.httpServer.Serve(ln)
+	if err := server.startAdmin(); err != nil {
+		t.Fatalf("Failed to start admin listener: %v", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// Hold a public request open so the main listener has to drain
+	inFlight := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/api/tasks")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+
+	// Wait until the request has reached the backend
+	for server.metrics.Snapshot().InFlight == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- server.Shutdown(context.Background())
+	}()
+
+	for !server.Draining() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The admin listener still answers and reports the drain
+	resp, err := client.Get("http://" + server.adminListener.Addr().String() + "/api/readyz")
+	if err != nil {
+		t.Fatalf("Expected admin listener to respond while draining: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected admin readiness 503 while draining, got %d", resp.StatusCode)
+	}
+
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown returned before in-flight request finished: %v", err)
+	default:
+	}
+
+	close(release)
+
+	if status := <-inFlight; status != http.StatusOK {
+		t.Errorf("Expected in-flight request to complete with 200, got %d", status)
+	}
+	if err := <-shutdownDone; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+
+	if _, err := client.Get("http://" + server.adminListener.Addr().String() + "/api/readyz"); err == nil {
+		t.Error("Expected admin listener to be closed after shutdown")
+	}
+}
+
+func TestStaticDefaultCharset(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"data.json": `{"ok":true}`,
+		"style.css": "body {}",
+		"pixel.png": "\x89PNG\r\n\x1a\n",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.DefaultCharset = "utf-8"
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/data.json", "application/json; charset=utf-8"},
+		// Types that already carry a charset are left alone
+		{"/style.css", "text/css; charset=utf-8"},
+		{"/pixel.png", "image/png"},
+	}
+
+	for _, tt := range tests {
+		rr := serve(server, httptest.NewRequest("GET", tt.path, nil))
+		if ct := rr.Header().Get("Content-Type"); ct != tt.expected {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.path, tt.expected, ct)
+		}
+	}
+
+	cfg.Static.DefaultCharset = ""
+	server = newTestServer(t, cfg)
+
+	// JSON keeps its charset even with the default disabled
+	rr := serve(server, httptest.NewRequest("GET", "/data.json", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Expected JSON to keep charset=utf-8 when disabled, got %q", ct)
+	}
+}
+
+func TestHandleCustomRoute(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	server := newTestServer(t, cfg)
+
+	server.HandleFunc("/hooks/deploy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("deployed"))
+	})
+
+	rr := serve(server, httptest.NewRequest("GET", "/hooks/deploy", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "deployed" {
+		t.Fatalf("Expected custom route to be served, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Custom routes run behind the middleware chain
+	if v := rr.Header().Get("X-Content-Type-Options"); v != "nosniff" {
+		t.Errorf("Expected security headers on custom route, got %q", v)
+	}
+	if total := server.metrics.Snapshot().Requests; total != 1 {
+		t.Errorf("Expected custom route to be counted, got %d", total)
+	}
+
+	for _, pattern := range []string{"/api/hello", "/api/custom", "example.com/api/"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected Handle(%q) to panic", pattern)
+				}
+			}()
+			server.Handle(pattern, http.NotFoundHandler())
+		}()
+	}
+}
+
+func TestMaxURILength(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.MaxURILength = 64
+	server := newTestServer(t, cfg)
+
+	if rr := serve(server, httptest.NewRequest("GET", "/api/hello?q=short", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected short URI to be served, got %d", rr.Code)
+	}
+
+	long := "/api/tasks?filter=" + strings.Repeat("x", 64)
+	if rr := serve(server, httptest.NewRequest("GET", long, nil)); rr.Code != http.StatusRequestURITooLong {
+		t.Errorf("Expected 414 for over-length URI, got %d", rr.Code)
+	}
+}
+
+func TestAccessLogRotationCompressesFiles(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+
+	file, err := openRotatingFile(logPath, 64, true)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer file.Close()
+
+	first := strings.Repeat("a", 40) + "\n"
+	second := strings.Repeat("b", 40) + "\n"
+	file.Write([]byte(first))
+	file.Write([]byte(second))
+
+	// Compression finishes in the background
+	file.compressing.Wait()
+
+	rotated, _ := filepath.Glob(logPath + ".*")
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".gz") {
+		t.Fatalf("Expected one gzipped rotated file, got %v", rotated)
+	}
+
+	f, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatalf("Failed to open rotated file: %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Rotated file is not valid gzip: %v", err)
+	}
+	content, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress rotated file: %v", err)
+	}
+	if string(content) != first {
+		t.Errorf("Expected rotated file to hold the first line, got %q", content)
+	}
+
+	current, _ := os.ReadFile(logPath)
+	if string(current) != second {
+		t.Errorf("Expected current log to hold the second line, got %q", current)
+	}
+}
+
+func TestProxyMaxConnAge(t *testing.T) {
+	var remotes []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remotes = append(remotes, r.RemoteAddr)
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.MaxConnAge = 50 * time.Millisecond
+	server := newTestServer(t, cfg)
+	defer server.Shutdown(context.Background())
+
+	serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	time.Sleep(120 * time.Millisecond)
+	serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+
+	if len(remotes) != 3 {
+		t.Fatalf("Expected 3 upstream requests, got %d", len(remotes))
+	}
+	if remotes[0] != remotes[1] {
+		t.Errorf("Expected a young connection to be reused, got %s and %s", remotes[0], remotes[1])
+	}
+	if remotes[2] == remotes[0] {
+		t.Errorf("Expected connection older than max age to be replaced, reused %s", remotes[2])
+	}
+}
+
+func TestExpvarCounters(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	server := newTestServer(t, cfg)
+
+	if rr := serve(server, httptest.NewRequest("GET", "/debug/vars", nil)); rr.Code == http.StatusOK && strings.Contains(rr.Body.String(), "memstats") {
+		t.Fatal("Expected /debug/vars to be disabled by default")
+	}
+
+	cfg.Server.EnableExpvar = true
+	server = newTestServer(t, cfg)
+
+	scrape := func() map[string]interface{} {
+		rr := serve(server, httptest.NewRequest("GET", "/debug/vars", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 from /debug/vars, got %d", rr.Code)
+		}
+
+		var vars map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &vars); err != nil {
+			t.Fatalf("Failed to parse /debug/vars: %v", err)
+		}
+		if _, ok := vars["memstats"]; !ok {
+			t.Error("Expected standard memstats variable")
+		}
+
+		stats, ok := vars["featherjet"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expected featherjet counters, got %v", vars["featherjet"])
+		}
+		for _, key := range []string{"requests", "bytes_sent", "in_flight", "status", "uptime_seconds"} {
+			if _, ok := stats[key]; !ok {
+				t.Errorf("Expected counter %q", key)
+			}
+		}
+		return stats
+	}
+
+	before := scrape()["requests"].(float64)
+	serve(server, httptest.NewRequest("GET", "/api/hello", nil))
+	after := scrape()["requests"].(float64)
+
+	// The first scrape and /api/hello are both counted
+	if after != before+2 {
+		t.Errorf("Expected requests to grow from %v to %v, got %v", before, before+2, after)
+	}
+}
+
+func TestMaintenanceAllowlist(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"index.html": "<h1>real site</h1>"})
+
+	cfg := newTestConfig(dir)
+	cfg.Server.Maintenance = true
+	cfg.Server.MaintenanceAllowCIDRs = []string{"10.1.0.0/16", "192.0.2.7"}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		remoteAddr string
+		expected   int
+	}{
+		{"10.1.44.3:50000", http.StatusOK},
+		{"192.0.2.7:50000", http.StatusOK},
+		{"203.0.113.9:50000", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rr := serve(server, req)
+
+		if rr.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.remoteAddr, tt.expected, rr.Code)
+		}
+		if tt.expected == http.StatusOK && !strings.Contains(rr.Body.String(), "real site") {
+			t.Errorf("%s: expected the real site, got %q", tt.remoteAddr, rr.Body.String())
+		}
+	}
+
+	// Probes keep working for everyone
+	req := httptest.NewRequest("GET", "/api/readyz", nil)
+	req.RemoteAddr = "203.0.113.9:50000"
+	if rr := serve(server, req); rr.Code != http.StatusOK {
+		t.Errorf("Expected readiness to bypass maintenance, got %d", rr.Code)
+	}
+
+	server.SetMaintenance(false)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.9:50000"
+	if rr := serve(server, req); rr.Code != http.StatusOK {
+		t.Errorf("Expected site to be served after maintenance ends, got %d", rr.Code)
+	}
+}
+
+func TestProxyForwardsTrailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte(`[]`))
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer backend.Close()
+
+	fetchTrailer := func(forward bool) string {
+		cfg := newTestConfig(t.TempDir())
+		cfg.Proxy.Target = backend.URL
+		cfg.Proxy.ForwardTrailers = forward
+		server := newTestServer(t, cfg)
+
+		frontend := httptest.NewServer(server.httpServer.Handler)
+		defer frontend.Close()
+
+		resp, err := http.Get(frontend.URL + "/api/tasks")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		// Trailers are only available once the body has been consumed
+		io.ReadAll(resp.Body)
+		return resp.Trailer.Get("X-Checksum")
+	}
+
+	if trailer := fetchTrailer(true); trailer != "abc123" {
+		t.Errorf("Expected trailer X-Checksum=abc123, got %q", trailer)
+	}
+	if trailer := fetchTrailer(false); trailer != "" {
+		t.Errorf("Expected trailer to be dropped, got %q", trailer)
+	}
+}
+
+func TestDirectoryListingSort(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"files/b.txt": "bb",
+		"files/a.txt": "aaa",
+		"files/c.txt": "c",
+	})
+
+	// Give each file a distinct modification time: c, a, b from oldest
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, "files", name), mtime, mtime); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+	}
+
+	tests := []struct {
+		sort     string
+		expected []string
+	}{
+		{"name", []string{"a.txt", "b.txt", "c.txt"}},
+		{"name_desc", []string{"c.txt", "b.txt", "a.txt"}},
+		{"size", []string{"c.txt", "b.txt", "a.txt"}},
+		{"size_desc", []string{"a.txt", "b.txt", "c.txt"}},
+		{"modtime", []string{"c.txt", "a.txt", "b.txt"}},
+		{"modtime_desc", []string{"b.txt", "a.txt", "c.txt"}},
+	}
+
+	linkPattern := regexp.MustCompile(`<a href="[^"]*">([^<]*)</a>`)
+	for _, tt := range tests {
+		cfg := newTestConfig(dir)
+		cfg.Static.ListingSort = tt.sort
+		server := newTestServer(t, cfg)
+
+		rr := serve(server, httptest.NewRequest("GET", "/files/", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tt.sort, rr.Code)
+		}
+
+		var names []string
+		for _, match := range linkPattern.FindAllStringSubmatch(rr.Body.String(), -1) {
+			names = append(names, match[1])
+		}
+		if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%s: expected order %v, got %v", tt.sort, tt.expected, names)
+		}
+	}
+}
+
+func TestAPIHeadRequests(t *testing.T) {
+	server := newTestServer(t, newTestConfig(t.TempDir()))
+
+	for _, path := range []string{"/api/hello", "/api/status", "/api/info"} {
+		get := serve(server, httptest.NewRequest("GET", path, nil))
+		head := serve(server, httptest.NewRequest("HEAD", path, nil))
+
+		if head.Code != http.StatusOK {
+			t.Errorf("%s: expected HEAD status 200, got %d", path, head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("%s: expected empty HEAD body, got %q", path, head.Body.String())
+		}
+		if ct := head.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: expected JSON content type on HEAD, got %q", path, ct)
+		}
+		if cl := get.Header().Get("Content-Length"); cl != strconv.Itoa(get.Body.Len()) {
+			t.Errorf("%s: expected Content-Length %d on GET, got %q", path, get.Body.Len(), cl)
+		}
+		if head.Header().Get("Content-Length") == "" {
+			t.Errorf("%s: expected Content-Length on HEAD", path)
+		}
+	}
+}
+
+// fakeKeepAliveConn records the keep-alive settings applied to it
+type fakeKeepAliveConn struct {
+	net.Conn
+	enabled bool
+	period  time.Duration
+}
+
+func (c *fakeKeepAliveConn) SetKeepAlive(keepalive bool) error {
+	c.enabled = keepalive
+	return nil
+}
+
+func (c *fakeKeepAliveConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.period = d
+	return nil
+}
+
+// fakeListener hands out a single prepared connection
+type fakeListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (ln fakeListener) Accept() (net.Conn, error) {
+	return ln.conn, nil
+}
+
+func TestKeepAliveListener(t *testing.T) {
+	conn := &fakeKeepAliveConn{}
+	ln := keepAliveListener{Listener: fakeListener{conn: conn}, period: 45 * time.Second}
+
+	if _, err := ln.Accept(); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if !conn.enabled || conn.period != 45*time.Second {
+		t.Errorf("Expected keep-alive enabled with 45s period, got enabled=%v period=%v", conn.enabled, conn.period)
+	}
+
+	conn = &fakeKeepAliveConn{enabled: true}
+	ln = keepAliveListener{Listener: fakeListener{conn: conn}, period: -1}
+	ln.Accept()
+	if conn.enabled {
+		t.Error("Expected a negative period to disable keep-alive")
+	}
+}
+
+func TestStaticRedirects(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Static.Redirects = []config.Redirect{
+		{From: "/about-us.html", To: "/about/"},
+		{From: "/blog/*", To: "/articles/*", Status: http.StatusFound},
+		{From: "/docs/v1/*", To: "https://docs.example.com/", Status: http.StatusPermanentRedirect},
+	}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/about-us.html", http.StatusMovedPermanently, "/about/"},
+		{"/blog/2023/hello.html?ref=rss", http.StatusFound, "/articles/2023/hello.html?ref=rss"},
+		{"/docs/v1/install", http.StatusPermanentRedirect, "https://docs.example.com/"},
+	}
+
+	for _, tt := range tests {
+		rr := serve(server, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: expected Location %q, got %q", tt.path, tt.location, loc)
+		}
+	}
+
+	if rr := serve(server, httptest.NewRequest("GET", "/about-us.html.bak", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected exact redirect not to match a longer path, got %d", rr.Code)
+	}
+}
+
+func TestStaticRewrites(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"articles/first-post.html": "<h1>First post</h1>",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.Rewrites = []config.Rewrite{{From: "/blog/*", To: "/articles/*"}}
+	server := newTestServer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/blog/first-post.html", nil)
+	rr := serve(server, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected rewritten path to be served, got %d", rr.Code)
+	}
+	if rr.Body.String() != "<h1>First post</h1>" {
+		t.Errorf("Expected content of articles/first-post.html, got %q", rr.Body.String())
+	}
+	if loc := rr.Header().Get("Location"); loc != "" {
+		t.Errorf("Expected no redirect, got Location %q", loc)
+	}
+	if req.URL.Path != "/blog/first-post.html" {
+		t.Errorf("Expected client URL to be unchanged, got %q", req.URL.Path)
+	}
+}
+
+func TestProxyFallbackToStatic(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"api/tasks/sample.json": `{"sample":true}`,
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.FallbackToStatic = true
+	server := newTestServer(t, cfg)
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/tasks/sample.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected static fallback to be served, got %d", rr.Code)
+	}
+	if rr.Body.String() != `{"sample":true}` {
+		t.Errorf("Expected static file content, got %q", rr.Body.String())
+	}
+
+	// Without a matching file the upstream 404 is passed through
+	if rr := serve(server, httptest.NewRequest("GET", "/api/tasks/missing.json", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected upstream 404 for missing file, got %d", rr.Code)
+	}
+
+	cfg.Proxy.FallbackToStatic = false
+	server = newTestServer(t, cfg)
+	if rr := serve(server, httptest.NewRequest("GET", "/api/tasks/sample.json", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected no fallback when disabled, got %d", rr.Code)
+	}
+}
+
+func TestPrecompressOnStart(t *testing.T) {
+	dir := t.TempDir()
+	script := strings.Repeat("console.log('featherjet');\n", 100)
+	writeStaticFiles(t, dir, map[string]string{
+		"app.js":       script,
+		"css/site.css": strings.Repeat("body { margin: 0; }\n", 50),
+		"logo.png":     "\x89PNG\r\n\x1a\n",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.PrecompressOnStart = true
+	cfg.Static.PrecompressWorkers = 2
+	server := newTestServer(t, cfg)
+
+	for _, name := range []string{"app.js", "css/site.css"} {
+		if _, err := os.Stat(filepath.Join(dir, name+".gz")); err != nil {
+			t.Errorf("Expected %s.gz to be generated at startup: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logo.png.gz")); err == nil {
+		t.Error("Expected incompressible logo.png to be skipped")
+	}
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := serve(server, req)
+
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected precompressed response, got headers %v", rr.Header())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("Expected JavaScript content type, got %q", ct)
+	}
+
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected valid gzip body: %v", err)
+	}
+	body, _ := io.ReadAll(gz)
+	if string(body) != script {
+		t.Error("Expected decompressed body to match app.js")
+	}
+
+	// Clients without gzip support get the original file
+	rr = serve(server, httptest.NewRequest("GET", "/app.js", nil))
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != script {
+		t.Error("Expected uncompressed app.js for clients without gzip")
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	pl := proxyProtocolListener{Listener: ln}
+
+	v2 := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	v2 = append(v2, 0x21, 0x11, 0x00, 0x0c, 198, 51, 100, 4, 10, 0, 0, 1, 0x1f, 0x90, 0x01, 0xbb)
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string
+	}{
+		{"v1", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"), "203.0.113.7:56324"},
+		{"v2", v2, "198.51.100.4:8080"},
+	}
+
+	for _, tt := range tests {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: dial failed: %v", tt.name, err)
+		}
+		client.Write(append(tt.header, "hello"...))
+
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatalf("%s: accept failed: %v", tt.name, err)
+		}
+
+		if addr := conn.RemoteAddr().String(); addr != tt.expected {
+			t.Errorf("%s: expected remote address %s, got %s", tt.name, tt.expected, addr)
+		}
+
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("%s: expected payload after header, got %q (%v)", tt.name, buf, err)
+		}
+
+		conn.Close()
+		client.Close()
+	}
+
+	// Connections without a header are rejected
+	client, _ := net.Dial("tcp", ln.Addr().String())
+	defer client.Close()
+	client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected read to fail without a PROXY header")
+	}
+}
+
+func TestProxyRequireHTTPS(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.RequireHTTPS = true
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		proto      string
+		expected   int
+	}{
+		{"plain http", "203.0.113.9:40000", false, "", http.StatusForbidden},
+		{"direct https", "203.0.113.9:40000", true, "", http.StatusOK},
+		{"trusted proxy https", "10.0.0.5:40000", false, "https", http.StatusOK},
+		{"untrusted forwarded proto", "203.0.113.9:40000", false, "https", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/tasks", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+
+		if rr := serve(server, req); rr.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expected, rr.Code)
+		}
+	}
+}
+
+func TestProxyMidStreamFailure(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 100\r\n\r\npartial"))
+		conn.Close()
+	}))
+	defer backend.Close()
+
+	var logs bytes.Buffer
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	frontend := httptest.NewServer(server.httpServer.Handler)
+	defer frontend.Close()
+	frontend.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	// Depending on buffering the client sees either a dropped connection or
+	// a truncated body, but never bytes the backend did not send
+	if resp, err := http.Get(frontend.URL + "/api/tasks"); err == nil {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Error("Expected the client to see a truncated response")
+		}
+		if !strings.HasPrefix("partial", string(body)) {
+			t.Errorf("Expected at most the upstream bytes, got %q", body)
+		}
+	}
+
+	// Wait for the handler to finish logging before reading the buffer
+	frontend.Close()
+	if !strings.Contains(logs.String(), "aborted mid-stream") {
+		t.Errorf("Expected mid-stream abort to be logged, got %q", logs.String())
+	}
+}
+
+func TestProxyErrorPageBeforeFirstByte(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backendURL := backend.URL
+	backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backendURL
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	req := httptest.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := serve(server, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d", rr.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body["error"] != "bad gateway" {
+		t.Errorf("Expected a JSON error body, got %q", rr.Body.String())
+	}
+
+	rr = serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	if !strings.Contains(rr.Body.String(), "502 Bad Gateway") {
+		t.Errorf("Expected an HTML error page, got %q", rr.Body.String())
+	}
+}
+
+func TestProxyMaxResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 50; i++ {
+			w.Header().Set(fmt.Sprintf("X-Debug-%02d", i), "1")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	var logs bytes.Buffer
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.MaxResponseHeaders = 10
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+
+	debugHeaders := 0
+	for name := range rr.Header() {
+		if strings.HasPrefix(name, "X-Debug-") {
+			debugHeaders++
+		}
+	}
+	// Date, Content-Type and Content-Length count towards the cap
+	if debugHeaders == 0 || debugHeaders > 10 {
+		t.Errorf("Expected upstream headers to be capped at 10, got %d debug headers", debugHeaders)
+	}
+	if rr.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Error("Expected essential headers to be kept")
+	}
+	if !strings.Contains(logs.String(), "dropped excess upstream response headers") {
+		t.Errorf("Expected a warning about dropped headers, got %q", logs.String())
+	}
+}
+
+func TestStaticRequireDirectory(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "does-not-exist")
+
+	// Lenient by default: the server starts and serves 404s
+	cfg := newTestConfig(missing)
+	server, err := New(cfg, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("Expected lenient mode to start without the directory, got %v", err)
+	}
+	if rr := serve(server, httptest.NewRequest("GET", "/index.html", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a static directory, got %d", rr.Code)
+	}
+
+	cfg.Static.RequireDirectory = true
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "does-not-exist") {
+		t.Errorf("Expected fail-fast error naming the directory, got %v", err)
+	}
+
+	cfg = newTestConfig(t.TempDir())
+	cfg.Static.RequireDirectory = true
+	if _, err := New(cfg); err != nil {
+		t.Errorf("Expected existing directory to pass, got %v", err)
+	}
+}
+
+func TestLogTimeFormat(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Logging.TimeFormat = "rfc3339"
+	cfg.Logging.TimeZone = "UTC"
+
+	var out bytes.Buffer
+	newLogger(cfg, &out).Info("hello")
+
+	pattern := regexp.MustCompile(`^time=\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z level=INFO msg=hello\n$`)
+	if !pattern.MatchString(out.String()) {
+		t.Errorf("Expected an RFC3339 UTC timestamp, got %q", out.String())
+	}
+
+	cfg.Logging.TimeZone = "Not/AZone"
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an unknown time zone to be rejected")
+	}
+}
+
+func TestAPIContentNegotiation(t *testing.T) {
+	server := newTestServer(t, newTestConfig(t.TempDir()))
+
+	for _, path := range []string{"/api/info", "/api/status"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "application/yaml")
+		rr := serve(server, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/yaml" {
+			t.Errorf("%s: expected YAML content type, got %q", path, ct)
+		}
+		var decoded map[string]interface{}
+		if err := yaml.Unmarshal(rr.Body.Bytes(), &decoded); err != nil {
+			t.Errorf("%s: expected a valid YAML body: %v", path, err)
+		} else if decoded["timestamp"] == nil {
+			t.Errorf("%s: expected timestamp in YAML body, got %v", path, decoded)
+		}
+
+		// JSON stays the default, including for wildcard and JSON-first clients
+		for _, accept := range []string{"", "*/*", "application/json, application/yaml;q=0.5"} {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", accept)
+			rr := serve(server, req)
+
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("%s with Accept %q: expected JSON content type, got %q", path, accept, ct)
+			}
+			if !json.Valid(rr.Body.Bytes()) {
+				t.Errorf("%s with Accept %q: expected a valid JSON body", path, accept)
+			}
+		}
+	}
+}
+
+func TestAPIDisabledEndpoints(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.API.DisabledEndpoints = []string{"hello", "info"}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/api/hello", http.StatusNotFound},
+		{"/api/info", http.StatusNotFound},
+		{"/api/status", http.StatusOK},
+		{"/api/readyz", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if rr := serve(server, httptest.NewRequest("GET", tt.path, nil)); rr.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.expected, rr.Code)
+		}
+	}
+
+	cfg.API.DisabledEndpoints = []string{"readyz"}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an unknown endpoint name to be rejected")
+	}
+}
+
+func TestIPv6Host(t *testing.T) {
+	probe, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.Host = "::1"
+	cfg.Server.Port = port
+	server := newTestServer(t, cfg)
+
+	addr := net.JoinHostPort("::1", strconv.Itoa(port))
+	if server.httpServer.Addr != addr {
+		t.Fatalf("Expected listen address %q, got %q", addr, server.httpServer.Addr)
+	}
+
+	go server.Start()
+	defer server.Shutdown(context.Background())
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/api/hello"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expected server to serve on %s, got %v", addr, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 over IPv6, got %d", resp.StatusCode)
+	}
+}
+
+func TestSSEIdleTimeout(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.ReadTimeout = 200 * time.Millisecond
+	cfg.Server.WriteTimeout = 200 * time.Millisecond
+	cfg.Server.IdleTimeout = 200 * time.Millisecond
+	cfg.Server.SSEIdleTimeout = 2 * time.Second
+	server := newTestServer(t, cfg)
+
+	server.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 6; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+	server.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		time.Sleep(400 * time.Millisecond)
+		w.Write([]byte("late"))
+	})
+
+	ts := httptest.NewUnstartedServer(server.httpServer.Handler)
+	ts.Config.ReadTimeout = cfg.Server.ReadTimeout
+	ts.Config.WriteTimeout = cfg.Server.WriteTimeout
+	ts.Config.IdleTimeout = cfg.Server.IdleTimeout
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || strings.Count(string(body), "data: ") != 6 {
+		t.Errorf("Expected all 6 events past the write timeout, got %q (err %v)", body, err)
+	}
+
+	// Ordinary responses still hit the write timeout
+	if resp, err := http.Get(ts.URL + "/slow"); err == nil {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && string(body) == "late" {
+			t.Error("Expected a non-stream response to be cut off by the write timeout")
+		}
+	}
+}
+
+func TestWelcomePage(t *testing.T) {
+	dir := t.TempDir()
+	cfg := newTestConfig(dir)
+	cfg.Static.ShowWelcomePage = true
+	server := newTestServer(t, cfg)
+
+	rr := serve(server, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "FeatherJet is running") {
+		t.Fatalf("Expected welcome page for an empty directory, got %d %q", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `href="/api/status"`) {
+		t.Error("Expected the welcome page to link to /api/status")
+	}
+
+	// Real content wins as soon as it exists
+	writeStaticFiles(t, dir, map[string]string{"index.html": "<h1>My site</h1>"})
+	rr = serve(server, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), "My site") {
+		t.Errorf("Expected index.html to replace the welcome page, got %q", rr.Body.String())
+	}
+
+	// A missing directory also gets the welcome page
+	cfg = newTestConfig(filepath.Join(t.TempDir(), "missing"))
+	cfg.Static.ShowWelcomePage = true
+	rr = serve(newTestServer(t, cfg), httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "FeatherJet is running") {
+		t.Errorf("Expected welcome page for a missing directory, got %d", rr.Code)
+	}
+}
+
+func TestReloadCacheHeaders(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"app.js": "console.log('plain')"})
+	cfg := newTestConfig(dir)
+	server := newTestServer(t, cfg)
+
+	if cc := serve(server, httptest.NewRequest("GET", "/app.js", nil)).Header().Get("Cache-Control"); cc != "max-age=3600" {
+		t.Fatalf("Expected initial max-age=3600, got %q", cc)
+	}
+
+	reloaded := newTestConfig(dir)
+	reloaded.Static.CacheMaxAge = "600"
+	if err := server.ReloadCacheHeaders(reloaded); err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if cc := serve(server, httptest.NewRequest("GET", "/app.js", nil)).Header().Get("Cache-Control"); cc != "max-age=600" {
+		t.Errorf("Expected reloaded max-age=600, got %q", cc)
+	}
+
+	// An invalid config leaves the current policy in place
+	reloaded.Static.ImmutablePattern = "("
+	if err := server.ReloadCacheHeaders(reloaded); err == nil {
+		t.Error("Expected an invalid config to be rejected")
+	}
+	if cc := serve(server, httptest.NewRequest("GET", "/app.js", nil)).Header().Get("Cache-Control"); cc != "max-age=600" {
+		t.Errorf("Expected policy to survive a failed reload, got %q", cc)
+	}
+}
+
+func TestProxyGRPCWeb(t *testing.T) {
+	// A length-prefixed data frame followed by a trailer frame
+	frame := []byte{0x00, 0x00, 0x00, 0x00, 0x03, 0x0a, 0x01, 0x78}
+	trailerFrame := []byte("\x80\x00\x00\x00\x0fgrpc-status:0\r\n")
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/grpc-web+proto" {
+			t.Errorf("Expected gRPC-Web request content type upstream, got %q", ct)
+		}
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(frame)
+		w.(http.Flusher).Flush()
+		w.Write(trailerFrame)
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.ForwardTrailers = false
+	cfg.Proxy.GRPCWeb = true
+	cfg.Middleware.EnableCompression = true
+	server := newTestServer(t, cfg)
+
+	frontend := httptest.NewServer(server.httpServer.Handler)
+	defer frontend.Close()
+
+	req, _ := http.NewRequest("POST", frontend.URL+"/api/tasks/tasks.v1.Tasks/List", bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, append(frame, trailerFrame...)) {
+		t.Errorf("Expected gRPC-Web frames to pass through intact, got %q", body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/grpc-web+proto" {
+		t.Errorf("Expected content type to be preserved, got %q", ct)
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		t.Errorf("Expected gRPC-Web response to stay uncompressed, got %q", ce)
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Expected grpc-status trailer to be forwarded, got %q", status)
+	}
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("large asset ", 10000)
+	writeStaticFiles(t, dir, map[string]string{"bundle.js": content})
+
+	cfg := newTestConfig(dir)
+	cfg.Cache.SingleFlight = true
+	cfg.Cache.MaxFileSizeMB = 32
+	server := newTestServer(t, cfg)
+
+	var reads atomic.Int32
+	release := make(chan struct{})
+	server.fileLoader.readFile = func(name string) ([]byte, error) {
+		reads.Add(1)
+		<-release
+		return os.ReadFile(name)
+	}
+
+	const clients = 20
+	results := make(chan *httptest.ResponseRecorder, clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			results <- serve(server, httptest.NewRequest("GET", "/bundle.js", nil))
+		}()
+	}
+
+	// Give every request time to join the in-flight read
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < clients; i++ {
+		rr := <-results
+		if rr.Code != http.StatusOK || rr.Body.String() != content {
+			t.Errorf("Expected full file with 200, got %d (%d bytes)", rr.Code, rr.Body.Len())
+		}
+	}
+	if n := reads.Load(); n != 1 {
+		t.Errorf("Expected a single underlying read, got %d", n)
+	}
+}
+
+func TestStreamShutdownGrace(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = port
+	cfg.Server.StreamShutdownGrace = 200 * time.Millisecond
+	server := newTestServer(t, cfg)
+
+	// The handler never ends the stream on its own
+	server.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	go server.Start()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/events", port)); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	first := make([]byte, len("data: hello\n\n"))
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("Failed to read first event: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- server.Shutdown(ctx) }()
+
+	rest, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(rest), "event: shutdown") {
+		t.Errorf("Expected a shutdown event on the stream, got %q", rest)
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Expected shutdown to complete cleanly, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected shutdown within the grace period, took %v", elapsed)
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"logo.png": "png-v1"})
+
+	cfg := newTestConfig(dir)
+	cfg.Cache.Rules = []config.CacheRule{
+		{ContentType: "application/json", TTL: 100 * time.Millisecond},
+		{ContentType: "image/*", TTL: time.Hour, MaxSize: 1 << 20},
+	}
+	server := newTestServer(t, cfg)
+
+	var calls atomic.Int32
+	server.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d}`, calls.Add(1))
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		return serve(server, httptest.NewRequest("GET", path, nil))
+	}
+
+	get("/data")
+	get("/logo.png")
+	writeStaticFiles(t, dir, map[string]string{"logo.png": "png-v2"})
+
+	// Both are served from the cache while fresh
+	if rr := get("/data"); rr.Body.String() != `{"call":1}` || rr.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected cached JSON response, got %q (X-Cache %q)", rr.Body.String(), rr.Header().Get("X-Cache"))
+	}
+	if rr := get("/logo.png"); rr.Body.String() != "png-v1" {
+		t.Errorf("Expected cached image, got %q", rr.Body.String())
+	}
+
+	// The JSON rule expires long before the image rule
+	time.Sleep(150 * time.Millisecond)
+	if rr := get("/data"); rr.Body.String() != `{"call":2}` {
+		t.Errorf("Expected JSON to expire after its TTL, got %q", rr.Body.String())
+	}
+	if rr := get("/logo.png"); rr.Body.String() != "png-v1" || rr.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected image to stay cached, got %q", rr.Body.String())
+	}
+
+	cfg.Cache.Rules = []config.CacheRule{{ContentType: "[", TTL: time.Second}}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an invalid content type pattern to be rejected")
+	}
+}
+
+func TestCacheRulesVaryAcceptEncoding(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Cache.Rules = []config.CacheRule{{ContentType: "application/json", TTL: time.Hour}}
+	server := newTestServer(t, cfg)
+
+	var calls atomic.Int32
+	server.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Vary", "Accept-Encoding")
+		if !middleware.AcceptsGzip(r) {
+			w.Write([]byte(`{"plain":true}`))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"plain":false}`))
+		gz.Close()
+	})
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/data", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		return serve(server, req)
+	}
+
+	get("gzip, deflate")
+
+	// A client without gzip support never gets the cached gzip body
+	rr := get("")
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != `{"plain":true}` {
+		t.Errorf("Expected an identity response without Accept-Encoding, got %q encoded %q", rr.Body.String(), rr.Header().Get("Content-Encoding"))
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the identity request to reach the handler, got %d calls", calls.Load())
+	}
+
+	// Equivalent Accept-Encoding headers share the gzip entry
+	rr = get("Deflate;q=0.5, GZIP, br;q=0")
+	if rr.Header().Get("X-Cache") != "HIT" || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the cached gzip response, got X-Cache %q encoded %q", rr.Header().Get("X-Cache"), rr.Header().Get("Content-Encoding"))
+	}
+	if rr := get(""); rr.Header().Get("X-Cache") != "HIT" || rr.Body.String() != `{"plain":true}` {
+		t.Errorf("Expected the cached identity response, got %q (X-Cache %q)", rr.Body.String(), rr.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheRulesCredentialedRequests(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("public") != "" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		fmt.Fprintf(w, `{"user":%q}`, r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Cache.Rules = []config.CacheRule{{ContentType: "application/json", TTL: time.Hour}}
+	server := newTestServer(t, cfg)
+
+	get := func(path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", auth)
+		return serve(server, req)
+	}
+
+	// Each user's tasks come from the upstream, never from another user's entry
+	alice := get("/api/tasks", "Bearer alice")
+	bob := get("/api/tasks", "Bearer bob")
+	if calls.Load() != 2 {
+		t.Errorf("Expected both credentialed requests to reach the upstream, got %d calls", calls.Load())
+	}
+	if !strings.Contains(alice.Body.String(), "alice") || !strings.Contains(bob.Body.String(), "bob") {
+		t.Errorf("Expected each user to get their own response, got %q and %q", alice.Body.String(), bob.Body.String())
+	}
+
+	// Explicitly public responses may still be shared
+	get("/api/tasks?public=1", "Bearer alice")
+	if rr := get("/api/tasks?public=1", "Bearer bob"); rr.Header().Get("X-Cache") != "HIT" || calls.Load() != 3 {
+		t.Errorf("Expected a public response to be shared, got X-Cache %q after %d calls", rr.Header().Get("X-Cache"), calls.Load())
+	}
+}
+
+func TestOptionsRequests(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"index.html": "<h1>home</h1>"})
+	cfg := newTestConfig(dir)
+	cfg.Middleware.EnableCORS = true
+	// The proxy routes only exist with a target; it is never contacted
+	cfg.Proxy.Target = "http://127.0.0.1:1"
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path  string
+		allow string
+	}{
+		{"/api/hello", "GET, HEAD, OPTIONS"},
+		{"/api/drain", "POST, OPTIONS"},
+		{"/index.html", "GET, HEAD, OPTIONS"},
+		{"/api/tasks/1", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"},
+	}
+
+	for _, tt := range tests {
+		rr := serve(server, httptest.NewRequest("OPTIONS", tt.path, nil))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("%s: expected 204, got %d", tt.path, rr.Code)
+		}
+		if allow := rr.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s: expected Allow %q, got %q", tt.path, tt.allow, allow)
+		}
+		if rr.Body.Len() != 0 {
+			t.Errorf("%s: expected empty body, got %q", tt.path, rr.Body.String())
+		}
+	}
+
+	// CORS preflights are answered by the CORS middleware
+	req := httptest.NewRequest("OPTIONS", "/api/hello", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rr := serve(server, req)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("Expected 204 CORS preflight, got %d with headers %v", rr.Code, rr.Header())
+	}
+}
+
+func TestRequestLogQueryRedaction(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := newTestConfig(t.TempDir())
+	cfg.Logging.EnableRequestLogging = true
+	cfg.Logging.LogQueryString = true
+	cfg.Logging.RedactQueryParams = []string{"email"}
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	serve(server, httptest.NewRequest("GET", "/api/hello?email=bob%40example.com&page=2", nil))
+	if !strings.Contains(logs.String(), "query=\"email=***&page=2\"") || strings.Contains(logs.String(), "example.com") {
+		t.Errorf("Expected email to be redacted from the request log, got %q", logs.String())
+	}
+
+	logs.Reset()
+	cfg.Logging.LogQueryString = false
+	server = newTestServer(t, cfg, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	serve(server, httptest.NewRequest("GET", "/api/hello?page=2", nil))
+	if strings.Contains(logs.String(), "query=") || !strings.Contains(logs.String(), "path=/api/hello") {
+		t.Errorf("Expected the query string to be omitted, got %q", logs.String())
+	}
+}
+
+func TestStaticAndProxyResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"app.js": "console.log('app')"})
+	cfg := newTestConfig(dir)
+	cfg.Proxy.Target = backend.URL
+	cfg.Static.ResponseHeaders = map[string]string{"Cache-Control": "public, max-age=86400", "X-Frame-Options": "SAMEORIGIN"}
+	cfg.Proxy.ResponseHeaders = map[string]string{"Cache-Control": "no-store"}
+	server := newTestServer(t, cfg)
+
+	static := serve(server, httptest.NewRequest("GET", "/app.js", nil))
+	if cc := static.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+		t.Errorf("Expected static Cache-Control override, got %q", cc)
+	}
+	if xfo := static.Header().Get("X-Frame-Options"); xfo != "SAMEORIGIN" {
+		t.Errorf("Expected static headers to override security defaults, got %q", xfo)
+	}
+
+	proxied := serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	if cc := proxied.Header().Values("Cache-Control"); len(cc) != 1 || cc[0] != "no-store" {
+		t.Errorf("Expected proxy Cache-Control no-store, got %q", cc)
+	}
+	if xfo := proxied.Header().Get("X-Frame-Options"); xfo != "DENY" {
+		t.Errorf("Expected static headers not to leak into proxy responses, got X-Frame-Options %q", xfo)
+	}
+
+	// API endpoints get neither set
+	if cc := serve(server, httptest.NewRequest("GET", "/api/hello", nil)).Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Expected no Cache-Control on built-in API responses, got %q", cc)
+	}
+}
+
+func TestProxyWeightedTargets(t *testing.T) {
+	var counts [3]atomic.Int32
+	var targets []config.ProxyTarget
+	for i, weight := range []int{5, 3, 2} {
+		i := i
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			counts[i].Add(1)
+			w.Write([]byte(`[]`))
+		}))
+		defer backend.Close()
+		targets = append(targets, config.ProxyTarget{URL: backend.URL, Weight: weight})
+	}
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Targets = targets
+	server := newTestServer(t, cfg)
+
+	for i := 0; i < 100; i++ {
+		if rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil)); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 from a backend, got %d", rr.Code)
+		}
+	}
+
+	for i, expected := range []int32{50, 30, 20} {
+		if got := counts[i].Load(); got < expected-5 || got > expected+5 {
+			t.Errorf("Backend %d (weight %d): expected about %d requests, got %d", i, targets[i].Weight, expected, got)
+		}
+	}
+
+	var info map[string]interface{}
+	rr := serve(server, httptest.NewRequest("GET", "/api/info", nil))
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode /api/info: %v", err)
+	}
+	reported, _ := info["proxy"].(map[string]interface{})["targets"].([]interface{})
+	if len(reported) != 3 || reported[0].(map[string]interface{})["weight"] != float64(5) {
+		t.Errorf("Expected effective weights in /api/info, got %v", info["proxy"])
+	}
+}
+
+func TestHeaderReadDeadline(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.Addr().String()
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = port
+	cfg.Server.HeaderReadDeadline = 200 * time.Millisecond
+	server := newTestServer(t, cfg)
+
+	go server.Start()
+	defer server.Shutdown(context.Background())
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get(fmt.Sprintf("http://%s/api/hello", addr)); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expected a prompt request to succeed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for a prompt request, got %d", resp.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Trickle header lines in well after the deadline has passed
+	go func() {
+		fmt.Fprint(conn, "GET /api/hello HTTP/1.1\r\nHost: localhost\r\n")
+		for i := 0; i < 40; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := fmt.Fprintf(conn, "X-Slow-%d: 1\r\n", i); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the slow connection to be dropped near the deadline, took %v", elapsed)
+	}
+
+	// Headers ending in bare LFs also lift the deadline, so a kept-alive
+	// connection keeps working past it
+	for _, terminator := range []string{"\n\n", "\r\n\n"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+
+		for i := 0; i < 2; i++ {
+			fmt.Fprint(conn, "GET /api/hello HTTP/1.1\nHost: localhost"+terminator)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("%q request %d: expected a response, got %v", terminator, i+1, err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%q request %d: expected 200, got %d", terminator, i+1, resp.StatusCode)
+			}
+			time.Sleep(300 * time.Millisecond)
+		}
+	}
+}
+
+func TestProxyJSONCharset(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("charset") == "latin1" {
+			w.Header().Set("Content-Type", "application/json; charset=iso-8859-1")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/tasks", "application/json; charset=utf-8"},
+		// An explicit upstream charset is preserved
+		{"/api/tasks?charset=latin1", "application/json; charset=iso-8859-1"},
+	}
+
+	for _, tt := range tests {
+		rr := serve(server, httptest.NewRequest("GET", tt.path, nil))
+		if ct := rr.Header().Get("Content-Type"); ct != tt.expected {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.path, tt.expected, ct)
+		}
+	}
+}
+
+func TestReadinessCheckDisk(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory permissions are not enforced on Windows")
+	}
+
+	logDir := t.TempDir()
+	cfg := newTestConfig(t.TempDir())
+	cfg.Logging.EnableRequestLogging = true
+	cfg.Logging.AccessLogFile = filepath.Join(logDir, "access.log")
+	cfg.Server.ReadinessCheckDisk = true
+	server := newTestServer(t, cfg)
+
+	if rr := serve(server, httptest.NewRequest("GET", "/api/readyz", nil)); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with a writable log directory, got %d", rr.Code)
+	}
+
+	if err := os.Chmod(logDir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(logDir, 0o755)
+	// Root ignores permission bits, so take the directory away instead
+	if os.Getuid() == 0 {
+		os.RemoveAll(logDir)
+	}
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 with a read-only log directory, got %d", rr.Code)
+	}
+
+	var body struct {
+		Status         string   `json:"status"`
+		UnwritableDirs []string `json:"unwritable_dirs"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body.Status != "unavailable" || len(body.UnwritableDirs) != 1 || body.UnwritableDirs[0] != logDir {
+		t.Errorf("Expected the read-only directory to be reported, got %+v", body)
+	}
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Logging.EnableRequestLogging = true
+	cfg.Middleware.EnableCORS = true
+	cfg.Middleware.EnableCompression = true
+	cfg.Server.MaxURILength = 2048
+	server := newTestServer(t, cfg)
+
+	expected := []string{
+		"metrics", "logger", "server_header", "max_uri_length", "maintenance",
+		"compression", "cors", "security", "options",
+	}
+	if got := server.middlewareNames; strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected middleware chain %v, got %v", expected, got)
+	}
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/info", nil))
+	var info struct {
+		Middleware struct {
+			Chain []string `json:"chain"`
+		} `json:"middleware"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode /api/info: %v", err)
+	}
+	if strings.Join(info.Middleware.Chain, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected /api/info to report chain %v, got %v", expected, info.Middleware.Chain)
+	}
+}
+
+func TestProxyBufferResponses(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		w.Header().Set("Content-Type", "application/json")
+		// Flushing forces chunked encoding upstream
+		w.Write([]byte(strings.Repeat("x", size/2)))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("x", size-size/2)))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.BufferResponses = true
+	cfg.Proxy.BufferMaxBytes = 1024
+	server := newTestServer(t, cfg)
+
+	frontend := httptest.NewServer(server.httpServer.Handler)
+	defer frontend.Close()
+
+	tests := []struct {
+		size     int
+		buffered bool
+	}{
+		{100, true},
+		{4096, false},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Get(fmt.Sprintf("%s/api/tasks?size=%d", frontend.URL, tt.size))
+		if err != nil {
+			t.Fatalf("size %d: request failed: %v", tt.size, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if len(body) != tt.size {
+			t.Errorf("size %d: expected the full body, got %d bytes", tt.size, len(body))
+		}
+		if tt.buffered && (resp.ContentLength != int64(tt.size) || len(resp.TransferEncoding) != 0) {
+			t.Errorf("size %d: expected Content-Length %d, got %d with transfer encoding %v",
+				tt.size, tt.size, resp.ContentLength, resp.TransferEncoding)
+		}
+		if !tt.buffered && (resp.ContentLength != -1 || len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked") {
+			t.Errorf("size %d: expected a chunked stream, got Content-Length %d with transfer encoding %v",
+				tt.size, resp.ContentLength, resp.TransferEncoding)
+		}
+	}
+}
+
+func TestProxyCanaryHeader(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-FJ-Canary-Backend") != "" {
+				t.Errorf("Expected the canary header to be stripped before proxying")
+			}
+			w.Write([]byte(name))
+		}))
+	}
+	stable, canary := backend("stable"), backend("canary")
+	defer stable.Close()
+	defer canary.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = stable.URL
+	cfg.Proxy.AllowCanaryHeader = true
+	cfg.Proxy.CanaryAllowCIDRs = []string{"10.0.0.0/8"}
+	cfg.Proxy.CanaryBackends = []string{canary.URL + "/"}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		remoteAddr string
+		backend    string
+		status     int
+		body       string
+	}{
+		{"10.1.2.3:4000", canary.URL, http.StatusOK, "canary"},
+		{"10.1.2.3:4000", "", http.StatusOK, "stable"},
+		// Untrusted clients are routed normally
+		{"203.0.113.7:4000", canary.URL, http.StatusOK, "stable"},
+		// Trusted clients cannot reach arbitrary hosts
+		{"10.1.2.3:4000", "http://169.254.169.254", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/tasks", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.backend != "" {
+			req.Header.Set("X-FJ-Canary-Backend", tt.backend)
+		}
+		rr := serve(server, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s via %q: expected %d, got %d", tt.remoteAddr, tt.backend, tt.status, rr.Code)
+			continue
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Errorf("%s via %q: expected %q backend, got %q", tt.remoteAddr, tt.backend, tt.body, rr.Body.String())
+		}
+	}
+
+	cfg.Proxy.CanaryBackends = []string{"not a url"}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an invalid canary backend to be rejected")
+	}
+}
+
+func TestGenerateSitemap(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"index.html":      "<h1>Home</h1>",
+		"about.html":      "<h1>About</h1>",
+		"docs/index.html": "<h1>Docs</h1>",
+		"app.js":          "console.log('app')",
+		".drafts/x.html":  "<h1>Draft</h1>",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.GenerateSitemap = true
+	cfg.Static.BlockDotfiles = true
+	server := newTestServer(t, cfg)
+
+	sitemapLocs := func() []string {
+		req := httptest.NewRequest("GET", "/sitemap.xml", nil)
+		req.Host = "example.com"
+		rr := serve(server, req)
+		if ct := rr.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+			t.Errorf("Expected an XML content type, got %q", ct)
+		}
+		return regexp.MustCompile(`<loc>([^<]*)</loc>`).FindAllString(rr.Body.String(), -1)
+	}
+
+	expected := []string{
+		"<loc>http://example.com/</loc>",
+		"<loc>http://example.com/about.html</loc>",
+		"<loc>http://example.com/docs/</loc>",
+	}
+	if got := sitemapLocs(); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected sitemap %v, got %v", expected, got)
+	}
+
+	// A new page shows up once its directory changes
+	writeStaticFiles(t, dir, map[string]string{"docs/setup.html": "<h1>Setup</h1>"})
+	os.Chtimes(filepath.Join(dir, "docs"), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if got := sitemapLocs(); len(got) != 4 || got[3] != "<loc>http://example.com/docs/setup.html</loc>" {
+		t.Errorf("Expected the new page to be listed, got %v", got)
+	}
+}
+
+func TestProxyExpect100ContinueWrappedWriters(t *testing.T) {
+	payload := `{"tasks":"` + strings.Repeat("x", 4096) + `"}`
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reading the body makes the backend send 100 Continue
+		io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(payload))
+	}))
+	defer backend.Close()
+
+	// Every wrapper that records a status sees the relayed interim response
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.Expect100Continue = true
+	cfg.Middleware.EnableCompression = true
+	cfg.Server.RequestTimeout = 5 * time.Second
+	cfg.Server.EmitServerTiming = true
+	cfg.Logging.EnableRequestLogging = true
+	cfg.Cache.Rules = []config.CacheRule{{ContentType: "application/json", TTL: time.Minute}}
+	server := newTestServer(t, cfg)
+
+	frontend := httptest.NewServer(server.httpServer.Handler)
+	defer frontend.Close()
+
+	req, _ := http.NewRequest("POST", frontend.URL+"/api/tasks", strings.NewReader(`{"title":"x"}`))
+	req.Header.Set("Expect", "100-continue")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := frontend.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected the backend's 201 after 100 Continue, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the final response to be gzipped, got headers %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != payload {
+		t.Errorf("Expected the backend's body, got %d bytes", len(body))
+	}
+}
+
+func TestProxyExpect100Continue(t *testing.T) {
+	var expect, received atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect.Store(r.Header.Get("Expect"))
+		// Reading the body makes the backend send 100 Continue
+		body, _ := io.ReadAll(r.Body)
+		received.Store(string(body))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("stored"))
+	}))
+	defer backend.Close()
+
+	for _, enabled := range []bool{true, false} {
+		expect.Store("")
+		received.Store("")
+
+		cfg := newTestConfig(t.TempDir())
+		cfg.Proxy.Target = backend.URL
+		cfg.Proxy.Expect100Continue = enabled
+		cfg.Logging.EnableRequestLogging = true
+		server := newTestServer(t, cfg)
+
+		frontend := httptest.NewServer(server.httpServer.Handler)
+		defer frontend.Close()
+
+		conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		body := strings.Repeat("x", 4096)
+		fmt.Fprintf(conn, "POST /api/tasks HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", len(body))
+
+		// The body is only sent once the interim response arrives
+		reader := bufio.NewReader(conn)
+		interim, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("enabled=%v: failed to read interim response: %v", enabled, err)
+		}
+		if interim.StatusCode != http.StatusContinue {
+			t.Fatalf("enabled=%v: expected 100 Continue before the body, got %d", enabled, interim.StatusCode)
+		}
+
+		io.WriteString(conn, body)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("enabled=%v: failed to read final response: %v", enabled, err)
+		}
+		final, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated || string(final) != "stored" {
+			t.Errorf("enabled=%v: expected the upload to complete with 201, got %d %q", enabled, resp.StatusCode, final)
+		}
+		if got, _ := received.Load().(string); got != body {
+			t.Errorf("enabled=%v: expected the backend to receive the full body, got %d bytes", enabled, len(got))
+		}
+
+		expected := ""
+		if enabled {
+			expected = "100-continue"
+		}
+		if got, _ := expect.Load().(string); got != expected {
+			t.Errorf("enabled=%v: expected the backend to see Expect %q, got %q", enabled, expected, got)
+		}
+	}
+}
+
+func TestProxyStreamsLargeUploads(t *testing.T) {
+	var received atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		received.Store(n)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	const limit = 64 << 20
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.MaxRequestBodyBytes = limit
+	server := newTestServer(t, cfg)
+
+	frontend := httptest.NewServer(server.httpServer.Handler)
+	defer frontend.Close()
+
+	upload := func(size int64, chunked bool) int {
+		body := io.LimitReader(zeroReader{}, size)
+		req, _ := http.NewRequest("POST", frontend.URL+"/api/tasks", body)
+		if !chunked {
+			req.ContentLength = size
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Upload of %d bytes failed: %v", size, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A streamed upload allocates a small fraction of its size
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if status := upload(limit, true); status != http.StatusCreated {
+		t.Fatalf("Expected a 64 MB upload to succeed, got %d", status)
+	}
+	runtime.ReadMemStats(&after)
+
+	if received.Load() != limit {
+		t.Errorf("Expected the backend to receive %d bytes, got %d", limit, received.Load())
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > limit/4 {
+		t.Errorf("Expected the upload to be streamed, but %d bytes were allocated", allocated)
+	}
+
+	if status := upload(limit+1, false); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized Content-Length, got %d", status)
+	}
+	if status := upload(limit+1, true); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized chunked upload, got %d", status)
+	}
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestEmitServerTiming(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.EmitServerTiming = true
+	server := newTestServer(t, cfg)
+
+	// Parses "name;dur=1.234" metrics into durations in milliseconds
+	metric := regexp.MustCompile(`^(\w+);dur=(\d+\.\d{3})$`)
+	timings := func(rr *httptest.ResponseRecorder) map[string]float64 {
+		parsed := map[string]float64{}
+		for _, value := range rr.Header().Values("Server-Timing") {
+			for _, entry := range strings.Split(value, ",") {
+				m := metric.FindStringSubmatch(strings.TrimSpace(entry))
+				if m == nil {
+					t.Fatalf("Unparseable Server-Timing entry %q", entry)
+				}
+				parsed[m[1]], _ = strconv.ParseFloat(m[2], 64)
+			}
+		}
+		return parsed
+	}
+
+	api := timings(serve(server, httptest.NewRequest("GET", "/api/hello", nil)))
+	if _, ok := api["handler"]; !ok || len(api) != 1 {
+		t.Errorf("Expected only a handler metric for a built-in endpoint, got %v", api)
+	}
+
+	proxied := timings(serve(server, httptest.NewRequest("GET", "/api/tasks", nil)))
+	if proxied["upstream"] < 20 {
+		t.Errorf("Expected an upstream metric of at least 20ms, got %v", proxied)
+	}
+	if proxied["handler"] < proxied["upstream"] {
+		t.Errorf("Expected the handler metric to include the upstream time, got %v", proxied)
+	}
+}
+
+func TestCORSRouteOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"app.js": "console.log('app')"})
+	cfg := newTestConfig(dir)
+	cfg.Middleware.EnableCORS = true
+	cfg.Middleware.CORSRoutes = []config.CORSRoute{{
+		PathPrefix: "/api",
+		CORSPolicy: config.CORSPolicy{
+			AllowOrigins: []string{"https://app.example.com"},
+			AllowHeaders: []string{"Content-Type", "X-Request-ID"},
+		},
+	}}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path    string
+		origin  string
+		allowed string
+	}{
+		{"/app.js", "https://evil.example", "*"},
+		{"/api/hello", "https://app.example.com", "https://app.example.com"},
+		{"/api/hello", "https://evil.example", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Origin", tt.origin)
+		rr := serve(server, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.allowed {
+			t.Errorf("%s from %s: expected Access-Control-Allow-Origin %q, got %q", tt.path, tt.origin, tt.allowed, got)
+		}
+	}
+
+	// Preflights use the route's policy too
+	req := httptest.NewRequest("OPTIONS", "/api/tasks", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := serve(server, req)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Headers") != "Content-Type, X-Request-ID" {
+		t.Errorf("Expected a 204 preflight with the route's headers, got %d %v", rr.Code, rr.Header())
+	}
+	if vary := rr.Header().Values("Vary"); !slices.Contains(vary, "Origin") {
+		t.Errorf("Expected Vary: Origin for an origin allowlist, got %v", vary)
+	}
+}
+
+func TestLogStacks(t *testing.T) {
+	stacks := goroutineStacks()
+	if !strings.HasPrefix(stacks, "goroutine ") || !strings.Contains(stacks, "TestLogStacks") {
+		t.Errorf("Expected a stack trace including this test, got %q", stacks)
+	}
+
+	var logs bytes.Buffer
+	server := newTestServer(t, newTestConfig(t.TempDir()), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	server.LogStacks()
+
+	if !strings.Contains(logs.String(), "goroutine stack dump") || !strings.Contains(logs.String(), "TestLogStacks") {
+		t.Errorf("Expected the stack dump in the server log, got %q", logs.String())
+	}
+}
+
+func TestRequiredHeaders(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Security.RequiredHeaders = map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "no-referrer",
+	}
+	server := newTestServer(t, cfg)
+
+	// The handler tries to drop or weaken every required header
+	server.HandleFunc("/careless", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Del("X-Content-Type-Options")
+		w.Header().Set("Referrer-Policy", "unsafe-url")
+		w.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/careless", "/api/hello"} {
+		rr := serve(server, httptest.NewRequest("GET", path, nil))
+		for name, value := range cfg.Security.RequiredHeaders {
+			if got := rr.Header().Values(name); len(got) != 1 || got[0] != value {
+				t.Errorf("%s: expected %s: %s, got %q", path, name, value, got)
+			}
+		}
+	}
+
+	cfg.Security.RequiredHeaders = map[string]string{"Bad Header": "x"}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an invalid required header name to be rejected")
+	}
+	cfg.Security.RequiredHeaders = map[string]string{"X-Empty": " "}
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an empty required header value to be rejected")
+	}
+}
+
+func TestProxyMaxConcurrentPerBackend(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 2)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") == "1" {
+			arrived <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.MaxConcurrentPerBackend = 2
+	server := newTestServer(t, cfg)
+
+	// Saturate the backend with two slow requests
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- serve(server, httptest.NewRequest("GET", "/api/tasks?block=1", nil)).Code
+		}()
+	}
+	<-arrived
+	<-arrived
+
+	rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 past the concurrency cap, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on the 503")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("Expected the in-flight requests to complete, got %d", code)
+		}
+	}
+
+	if rr := serve(server, httptest.NewRequest("GET", "/api/tasks", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected requests to succeed once slots are released, got %d", rr.Code)
+	}
+}
+
+func TestStaticLanguageNegotiation(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"index.en.html":   "<h1>Hello</h1>",
+		"index.fr.html":   "<h1>Bonjour</h1>",
+		"about.html":      "<h1>About</h1>",
+		"about.de.html":   "<h1>Über uns</h1>",
+		"docs/index.html": "<h1>Docs</h1>",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.LanguageNegotiation = true
+	cfg.Static.DefaultLanguage = "en"
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path           string
+		acceptLanguage string
+		body           string
+	}{
+		{"/", "fr", "<h1>Bonjour</h1>"},
+		{"/", "fr-CA,fr;q=0.9,en;q=0.8", "<h1>Bonjour</h1>"},
+		{"/", "de, en;q=0.5", "<h1>Hello</h1>"},
+		// No untagged index.html, so the default language is used
+		{"/", "ja", "<h1>Hello</h1>"},
+		{"/about.html", "de-AT", "<h1>Über uns</h1>"},
+		{"/about.html", "fr", "<h1>About</h1>"},
+		{"/docs/", "fr", "<h1>Docs</h1>"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		rr := serve(server, req)
+
+		if rr.Code != http.StatusOK || rr.Body.String() != tt.body {
+			t.Errorf("%s with %q: expected %q, got %d %q", tt.path, tt.acceptLanguage, tt.body, rr.Code, rr.Body.String())
+		}
+		if vary := rr.Header().Values("Vary"); !slices.Contains(vary, "Accept-Language") {
+			t.Errorf("%s: expected Vary: Accept-Language, got %v", tt.path, vary)
+		}
+	}
+}
+
+func TestAPIPrettyJSON(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		cfg := newTestConfig(t.TempDir())
+		cfg.API.PrettyJSON = pretty
+		server := newTestServer(t, cfg)
+
+		for _, path := range []string{"/api/hello", "/api/status", "/api/info"} {
+			rr := serve(server, httptest.NewRequest("GET", path, nil))
+			body := rr.Body.String()
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("%s: invalid JSON: %v", path, err)
+			}
+
+			indented := strings.HasPrefix(body, "{\n  \"")
+			if indented != pretty {
+				t.Errorf("%s with pretty_json=%v: unexpected body %q", path, pretty, body)
+			}
+			if !pretty && strings.Count(body, "\n") != 1 {
+				t.Errorf("%s: expected single-line compact JSON, got %q", path, body)
+			}
+			if cl := rr.Header().Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+				t.Errorf("%s: Content-Length %s does not match body length %d", path, cl, len(body))
+			}
+		}
+	}
+}
+
+func TestProxyContextHeaders(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Tenant-ID")
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Middleware.ContextHeaders = []config.ContextHeader{
+		// Anchored by the server, so "acme-1x" does not match on a prefix
+		{Name: "X-Tenant-ID", Pattern: `[a-z]+-[0-9]+`},
+	}
+	server := newTestServer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("X-Tenant-ID", "acme-1")
+	if rr := serve(server, req); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a valid tenant, got %d", rr.Code)
+	}
+	if tenant := <-received; tenant != "acme-1" {
+		t.Errorf("Expected backend to receive tenant acme-1, got %q", tenant)
+	}
+
+	for _, tenant := range []string{"acme-1x", "../admin"} {
+		req := httptest.NewRequest("GET", "/api/tasks", nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		if rr := serve(server, req); rr.Code != http.StatusBadRequest {
+			t.Errorf("Tenant %q: expected 400, got %d", tenant, rr.Code)
+		}
+	}
+	select {
+	case tenant := <-received:
+		t.Errorf("Rejected tenant %q reached the backend", tenant)
+	default:
+	}
+}
+
+func TestStaticWatchInterval(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{"page.html": "version one"})
+
+	cfg := newTestConfig(dir)
+	cfg.Cache.Rules = []config.CacheRule{{ContentType: "text/html", TTL: time.Hour}}
+	cfg.Static.WatchInterval = 20 * time.Millisecond
+	server := newTestServer(t, cfg)
+	defer server.stopStaticWatch()
+
+	get := func() *httptest.ResponseRecorder {
+		return serve(server, httptest.NewRequest("GET", "/page.html", nil))
+	}
+
+	get()
+	if rr := get(); rr.Header().Get("X-Cache") != "HIT" || rr.Body.String() != "version one" {
+		t.Fatalf("Expected cached first version, got %q (X-Cache %q)", rr.Body.String(), rr.Header().Get("X-Cache"))
+	}
+
+	file := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(file, []byte("version two!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(file, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if body := get().Body.String(); body == "version two!" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected the changed file to be served after the watch interval, still got %q", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyRequestHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.MaxRequestHeaderBytes = 1024
+	server := newTestServer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Connection", "keep-alive, X-Internal-Hop")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("X-Internal-Hop", "secret")
+	req.Header["x-lower-case"] = []string{"kept"}
+	if rr := serve(server, req); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	header := <-received
+	for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "X-Internal-Hop"} {
+		if value := header.Get(name); value != "" {
+			t.Errorf("Expected hop-by-hop header %s to be stripped, got %q", name, value)
+		}
+	}
+	if header.Get("X-Lower-Case") != "kept" {
+		t.Errorf("Expected end-to-end header to be forwarded canonicalized, got %v", header)
+	}
+
+	req = httptest.NewRequest("GET", "/api/tasks", nil)
+	req.Header.Set("Cookie", strings.Repeat("a", 2048))
+	if rr := serve(server, req); rr.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("Expected 431 for oversized headers, got %d", rr.Code)
+	}
+	select {
+	case <-received:
+		t.Error("Oversized request reached the backend")
+	default:
+	}
+}
+
+func TestStaticEmitSRI(t *testing.T) {
+	dir := t.TempDir()
+	writeStaticFiles(t, dir, map[string]string{
+		"js/app.js":  "alert(1)",
+		"style.css":  "body{}",
+		"index.html": "<h1>Home</h1>",
+	})
+
+	cfg := newTestConfig(dir)
+	cfg.Static.EmitSRI = true
+	server := newTestServer(t, cfg)
+
+	fetch := func() map[string]string {
+		rr := serve(server, httptest.NewRequest("GET", "/api/sri", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+		var hashes map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &hashes); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return hashes
+	}
+
+	// Known answer: printf 'alert(1)' | openssl dgst -sha384 -binary | base64
+	hashes := fetch()
+	if expected := "sha384-HT2E9NfWiuQ/w1PRai+hTyqW16NIoCGA/m8VQDUopfAtcz6YQjtsMmQd5uRbVDpW"; hashes["/js/app.js"] != expected {
+		t.Errorf("Expected %s for /js/app.js, got %q", expected, hashes["/js/app.js"])
+	}
+	if _, ok := hashes["/style.css"]; !ok {
+		t.Error("Expected stylesheet to be listed")
+	}
+	if _, ok := hashes["/index.html"]; ok {
+		t.Error("Expected HTML pages to be left out")
+	}
+
+	// A changed asset gets a fresh hash rather than the cached one
+	later := time.Now().Add(time.Minute)
+	writeStaticFiles(t, dir, map[string]string{"js/app.js": "alert(2)"})
+	os.Chtimes(filepath.Join(dir, "js", "app.js"), later, later)
+	if updated := fetch()["/js/app.js"]; updated == hashes["/js/app.js"] {
+		t.Error("Expected hash to be recomputed after the file changed")
+	}
+}
+
+func TestReadinessBackendTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("healthy") == "" {
+			<-release
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.ReadinessCheckBackend = true
+	cfg.Proxy.HealthCheckTimeout = 100 * time.Millisecond
+	server := newTestServer(t, cfg)
+
+	start := time.Now()
+	rr := serve(server, httptest.NewRequest("GET", "/api/readyz", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected readiness to give up after the health check timeout, took %v", elapsed)
+	}
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 for a hanging backend, got %d", rr.Code)
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["status"] != "unavailable" {
+		t.Errorf("Expected status unavailable, got %v", response["status"])
+	}
+	if backends, _ := response["unreachable_backends"].([]interface{}); len(backends) != 1 || backends[0] != backend.URL {
+		t.Errorf("Expected %s in unreachable_backends, got %v", backend.URL, response["unreachable_backends"])
+	}
+
+	cfg.Proxy.Target = backend.URL + "/?healthy=1"
+	server = newTestServer(t, cfg)
+	if rr := serve(server, httptest.NewRequest("GET", "/api/readyz", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a responsive backend, got %d", rr.Code)
+	}
+}
+
+func TestProxyRangePassthrough(t *testing.T) {
+	asset := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"asset-v1"`)
+		http.ServeContent(w, r, path.Base(r.URL.Path), modTime, bytes.NewReader(asset))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Middleware.EnableCompression = true
+	server := newTestServer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/api/tasks/export.bin", nil)
+	req.Header.Set("Range", "bytes=1000-1999")
+	req.Header.Set("If-Range", `"asset-v1"`)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := serve(server, req)
+
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("Expected 206, got %d", rr.Code)
+	}
+	if cr := rr.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 1000-1999/%d", len(asset)) {
+		t.Errorf("Unexpected Content-Range %q", cr)
+	}
+	if rr.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected Accept-Ranges: bytes, got %q", rr.Header().Get("Accept-Ranges"))
+	}
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("Expected partial content to be passed through uncompressed, got %q", ce)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), asset[1000:2000]) {
+		t.Errorf("Expected bytes 1000-1999 of the asset, got %d bytes", rr.Body.Len())
+	}
+
+	// A stale If-Range validator gets the whole resource instead
+	req = httptest.NewRequest("GET", "/api/tasks/export.bin", nil)
+	req.Header.Set("Range", "bytes=1000-1999")
+	req.Header.Set("If-Range", `"asset-v0"`)
+	if rr := serve(server, req); rr.Code != http.StatusOK || rr.Body.Len() != len(asset) {
+		t.Errorf("Expected full 200 response for a stale If-Range, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	// Ranges of the uncompressed body must not be offered for a gzipped one
+	req = httptest.NewRequest("GET", "/api/tasks/export.csv", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = serve(server, req)
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the CSV export to be compressed, got headers %v", rr.Header())
+	}
+	if ar := rr.Header().Get("Accept-Ranges"); ar != "" {
+		t.Errorf("Expected Accept-Ranges to be dropped from a compressed response, got %q", ar)
+	}
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.Addr().String()
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = port
+	cfg.Server.MaxConnectionsPerIP = 2
+	server := newTestServer(t, cfg)
+
+	go server.Start()
+	defer server.Shutdown(context.Background())
+
+	// hello sends a request on a fresh connection, reporting whether it was served
+	hello := func() (net.Conn, bool) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, false
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		fmt.Fprintf(conn, "GET /api/hello HTTP/1.1\r\nHost: %s\r\n\r\n", addr)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			conn.Close()
+			return nil, false
+		}
+		resp.Body.Close()
+		return conn, resp.StatusCode == http.StatusOK
+	}
+
+	var first net.Conn
+	for i := 0; i < 50; i++ {
+		var ok bool
+		if first, ok = hello(); ok {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if first == nil {
+		t.Fatal("Server did not start")
+	}
+	second, ok := hello()
+	if !ok {
+		t.Fatal("Expected a second connection to be allowed")
+	}
+	defer second.Close()
+
+	if conn, ok := hello(); ok {
+		conn.Close()
+		t.Fatal("Expected a third connection from the same IP to be refused")
+	}
+
+	// Closing a connection frees its slot once the server notices
+	first.Close()
+	for i := 0; ; i++ {
+		if conn, ok := hello(); ok {
+			conn.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal("Expected a new connection to be allowed after one closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestLogUpstreamTiming(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+
+	var logs bytes.Buffer
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Logging.EnableRequestLogging = true
+	cfg.Logging.LogUpstreamTiming = true
+	server := newTestServer(t, cfg, WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	serve(server, httptest.NewRequest("GET", "/api/tasks", nil))
+	serve(server, httptest.NewRequest("GET", "/api/hello", nil))
+
+	entries := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry["msg"] == "request" {
+			entries[entry["path"].(string)] = entry
+		}
+	}
+
+	proxied := entries["/api/tasks"]
+	upstream, ok := proxied["upstream_duration"].(float64)
+	if !ok {
+		t.Fatalf("Expected upstream_duration on the proxied request log, got %v", proxied)
+	}
+	if time.Duration(upstream) < 150*time.Millisecond {
+		t.Errorf("Expected upstream_duration to cover the slow backend, got %v", time.Duration(upstream))
+	}
+	if total := proxied["duration"].(float64); total < upstream {
+		t.Errorf("Expected total duration %v to include upstream %v", time.Duration(total), time.Duration(upstream))
+	}
+
+	if _, ok := entries["/api/hello"]["upstream_duration"]; ok {
+		t.Error("Expected no upstream_duration for a request that was not proxied")
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[]`))
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		}
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.RequestTimeout = 50 * time.Millisecond
+	cfg.Server.RouteTimeouts = []config.RouteTimeout{
+		{PathPrefix: "/api/tasks", Timeout: 2 * time.Second},
+		{PathPrefix: "/api/tasks/reports", Timeout: 50 * time.Millisecond},
+	}
+	server := newTestServer(t, cfg)
+
+	// The /api/tasks override gives the slow backend enough time
+	if rr := serve(server, httptest.NewRequest("GET", "/api/tasks/123", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 under the longer /api/tasks timeout, got %d", rr.Code)
+	}
+
+	// The longer /api/tasks/reports prefix wins with a shorter timeout
+	start := time.Now()
+	rr := serve(server, httptest.NewRequest("GET", "/api/tasks/reports/weekly", nil))
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected the request to be cut off after 50ms, took %v", elapsed)
+	}
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d", rr.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body["error"] != "gateway timeout" || body["timeout"] != "50ms" {
+		t.Errorf("Expected a JSON 504 body, got %q", rr.Body.String())
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("Expected the upstream request to be canceled")
+	}
+
+	// Other paths use the 50ms default; a handler that is already fast is unaffected
+	if rr := serve(server, httptest.NewRequest("GET", "/api/hello", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a fast handler, got %d", rr.Code)
+	}
+}
+
+func TestTLSCipherSuites(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.TLS.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}
+	cfg.TLS.PreferServerCipherSuites = true
+	server := newTestServer(t, cfg)
+
+	tlsConfig := server.httpServer.TLSConfig
+	if tlsConfig == nil {
+		t.Fatal("Expected a TLS config carrying the cipher suites")
+	}
+	expected := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+	if !slices.Equal(tlsConfig.CipherSuites, expected) {
+		t.Errorf("Expected cipher suites %v, got %v", expected, tlsConfig.CipherSuites)
+	}
+	if !tlsConfig.PreferServerCipherSuites {
+		t.Error("Expected PreferServerCipherSuites to be passed through")
+	}
+
+	// Autocert's TLS config keeps its certificate callback
+	cfg = newTestConfig(t.TempDir())
+	cfg.TLS.AutoCert.Domains = []string{"tasks.example.com"}
+	cfg.TLS.AutoCert.CacheDir = t.TempDir()
+	cfg.TLS.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	server = newTestServer(t, cfg)
+	if tlsConfig := server.httpServer.TLSConfig; tlsConfig.GetCertificate == nil || len(tlsConfig.CipherSuites) != 1 {
+		t.Error("Expected cipher suites to be added to the autocert TLS config")
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key to
+// dir and returns both paths
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestHTTP3(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(dir)
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = port
+	cfg.Server.EnableHTTP3 = true
+	cfg.TLS.CertFile, cfg.TLS.KeyFile = writeTestCert(t, dir)
+	server := newTestServer(t, cfg)
+
+	// Nothing is advertised before the QUIC listener is up
+	req := httptest.NewRequest("GET", "/api/hello", nil)
+	req.TLS = &tls.ConnectionState{}
+	if v := serve(server, req).Header().Get("Alt-Svc"); v != "" {
+		t.Errorf("Expected no Alt-Svc before listening, got %q", v)
+	}
+
+	go server.Start()
+	defer server.Shutdown(context.Background())
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("https://" + addr + "/api/hello"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expected HTTPS on %s, got %v", addr, err)
+	}
+	resp.Body.Close()
+	if v := resp.Header.Get("Alt-Svc"); !strings.Contains(v, fmt.Sprintf(`h3=":%d"`, port)) {
+		t.Errorf("Expected Alt-Svc advertising h3 on port %d, got %q", port, v)
+	}
+
+	// The same handlers answer over QUIC
+	h3 := &http3.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer h3.Close()
+	resp, err = (&http.Client{Transport: h3, Timeout: 5 * time.Second}).Get("https://" + addr + "/api/hello")
+	if err != nil {
+		t.Fatalf("Expected HTTP/3 on %s, got %v", addr, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 3 {
+		t.Errorf("Expected 200 over HTTP/3, got %d over %s: %q", resp.StatusCode, resp.Proto, body)
+	}
+
+	// Once QUIC is closed the advertisement stops
+	server.Shutdown(context.Background())
+	if v := serve(server, req).Header().Get("Alt-Svc"); v != "" {
+		t.Errorf("Expected no Alt-Svc after shutdown, got %q", v)
+	}
+}
+
+func TestProxyDisabled(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Backend should not be reached, got %s", r.URL.Path)
+	}))
+	defer backend.Close()
+
+	disabled := false
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.Enabled = &disabled
+	server := newTestServer(t, cfg)
+
+	if server.proxy != nil {
+		t.Error("Expected no proxy to be built when disabled")
+	}
+	for _, path := range []string{"/api/tasks", "/api/tasks/42"} {
+		req := httptest.NewRequest("GET", path, nil)
+		if _, pattern := server.mux.Handler(req); strings.HasPrefix(pattern, "/api/tasks") {
+			t.Errorf("%s: expected no proxy route, matched %q", path, pattern)
+		}
+
+		req.Header.Set("Accept", "application/json")
+		rr := serve(server, req)
+		if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), `"not found"`) {
+			t.Errorf("%s: expected a JSON 404, got %d %q", path, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Without an explicit setting the proxy follows whether a target is set
+	cfg = newTestConfig(t.TempDir())
+	if server := newTestServer(t, cfg); server.proxy != nil {
+		t.Error("Expected no proxy without a target")
+	}
+	cfg.Proxy.Target = backend.URL
+	if server := newTestServer(t, cfg); server.proxy == nil {
+		t.Error("Expected the proxy to be enabled by a target")
+	}
+
+	// Enabling it without a target is a configuration error
+	enabled := true
+	cfg = newTestConfig(t.TempDir())
+	cfg.Proxy.Enabled = &enabled
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an enabled proxy without a target to be rejected")
+	}
+}
+
+func TestProxyWebSocketPings(t *testing.T) {
+	pongs := make(chan byte, 16)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Write([]byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o'})
+		buf.Flush()
+
+		// Stay silent, only recording the opcodes of the client's frames
+		header := make([]byte, 6)
+		for {
+			if _, err := io.ReadFull(buf, header); err != nil {
+				return
+			}
+			pongs <- header[0] & 0x0f
+		}
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Proxy.WebSocketPingInterval = 50 * time.Millisecond
+	server := newTestServer(t, cfg)
+	front := httptest.NewServer(server.httpServer.Handler)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /api/tasks/live HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+
+	// An intermediary that drops connections idle for 150ms: reading fails
+	// unless something arrives in time
+	readFrame := func() []byte {
+		conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatalf("Connection went idle: %v", err)
+		}
+		payload := make([]byte, header[1]&0x7f)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			t.Fatal(err)
+		}
+		return append(header, payload...)
+	}
+
+	if frame := readFrame(); string(frame[2:]) != "hello" {
+		t.Fatalf("Expected the backend's first message, got %q", frame)
+	}
+
+	pings := 0
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		frame := readFrame()
+		if frame[0] != 0x89 {
+			t.Fatalf("Expected only pings from an idle backend, got %x", frame)
+		}
+		pings++
+		// Masked pong with an empty payload
+		conn.Write([]byte{0x8a, 0x80, 1, 2, 3, 4})
+	}
+	if pings < 3 {
+		t.Errorf("Expected regular pings over 500ms, got %d", pings)
+	}
+	if opcode := <-pongs; opcode != 0x0a {
+		t.Errorf("Expected the client's pongs to reach the backend, got opcode %x", opcode)
+	}
+}
+
+func TestStreamShutdownAfterPartialEvent(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.Server.StreamShutdownGrace = time.Second
+	server := newTestServer(t, cfg)
+
+	started := make(chan struct{})
+	resume := make(chan struct{})
+	lateWrite := make(chan error, 1)
+	server.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: hello\n\nevent: update\ndata: par")
+		w.(http.Flusher).Flush()
+		close(started)
+		<-resume
+		fmt.Fprint(w, "tial\n\n")
+		_, err := fmt.Fprint(w, "data: after\n\n")
+		lateWrite <- err
+	})
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- serve(server, httptest.NewRequest("GET", "/events", nil)) }()
+	<-started
+
+	// Shutdown starts while the handler is halfway through an event
+	for _, st := range server.streams.snapshot() {
+		st.announceShutdown()
+	}
+	close(resume)
+	rr := <-done
+
+	expected := "data: hello\n\nevent: update\ndata: partial\n\n" + shutdownEvent
+	if rr.Body.String() != expected {
+		t.Errorf("Expected the shutdown event after the partial event completed, got %q", rr.Body.String())
+	}
+	if err := <-lateWrite; err != errStreamClosed {
+		t.Errorf("Expected writes after the shutdown event to fail, got %v", err)
+	}
+}
+
+func TestStreamShutdownClosesWebSockets(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		io.Copy(io.Discard, buf)
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(t.TempDir())
+	cfg.Proxy.Target = backend.URL
+	cfg.Server.StreamShutdownGrace = 5 * time.Second
+	server := newTestServer(t, cfg)
+	front := httptest.NewServer(server.httpServer.Handler)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /api/tasks/live HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+
+	server.closeStreams(cfg.Server.StreamShutdownGrace)
+
+	// The close frame arrives right away, long before the grace period ends
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		t.Fatalf("Expected a close frame on shutdown: %v", err)
+	}
+	if !bytes.Equal(frame, []byte{0x88, 0x02, 0x03, 0xe9}) {
+		t.Errorf("Expected a 1001 going away close frame, got %x", frame)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to close after the close frame, got %v", err)
+	}
+}
+
+func TestAPICustomEndpoints(t *testing.T) {
+	cfg := newTestConfig(t.TempDir())
+	cfg.API.DisabledEndpoints = []string{"hello"}
+	cfg.API.Custom = map[string]config.CustomEndpoint{
+		"/api/flags":       {Body: `{"dark_mode":true,"beta":["search"]}`},
+		"/api/maintenance": {Status: http.StatusServiceUnavailable, Body: `{"error":"down for maintenance"}`},
+		"/api/hello":       {Body: `"mocked"`},
+	}
+	server := newTestServer(t, cfg)
+
+	tests := []struct {
+		path     string
+		expected int
+		body     string
+	}{
+		{"/api/flags", http.StatusOK, `{"dark_mode":true,"beta":["search"]}`},
+		{"/api/maintenance", http.StatusServiceUnavailable, `{"error":"down for maintenance"}`},
+		{"/api/hello", http.StatusOK, `"mocked"`},
+	}
+
+	for _, tt := range tests {
+		rr := serve(server, httptest.NewRequest("GET", tt.path, nil))
+		if rr.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.expected, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: expected a JSON content type, got %q", tt.path, ct)
+		}
+		if body := strings.TrimSpace(rr.Body.String()); body != tt.body {
+			t.Errorf("%s: expected body %s, got %s", tt.path, tt.body, body)
+		}
+	}
+
+	// Invalid JSON and clashes with enabled built-ins are rejected
+	for path, endpoint := range map[string]config.CustomEndpoint{
+		"/api/broken": {Body: `{"open":`},
+		"/api/status": {Body: `{}`},
+		"/api/tasks":  {Body: `[]`},
+		"/flags":      {Body: `{}`},
+		"/api/teapot": {Status: 99, Body: `{}`},
+	} {
+		cfg := newTestConfig(t.TempDir())
+		cfg.API.Custom = map[string]config.CustomEndpoint{path: endpoint}
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected custom endpoint %s %+v to be rejected", path, endpoint)
+		}
+	}
+}